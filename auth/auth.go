@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/grafana/memo/cfg"
+	log "github.com/sirupsen/logrus"
+)
+
+// Action a user wants to perform on a memo
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionEdit   Action = "edit"
+	ActionDelete Action = "delete"
+)
+
+// Subject describes who is acting, and where
+type Subject struct {
+	// Source is the service the request came from, e.g. "slack"
+	Source string
+	// UserID and UserName identify the user
+	UserID   string
+	UserName string
+	// ChannelID and ChannelName identify the channel
+	ChannelID   string
+	ChannelName string
+	// Groups are the IDs and names of the slack user groups or discord
+	// roles of the user
+	Groups []string
+}
+
+// DeniedError is returned when a subject is not allowed to act
+type DeniedError struct {
+	Action Action
+	Reason string
+}
+
+// Error implements error
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("you are not allowed to %s memos %s", e.Action, e.Reason)
+}
+
+// set is a case-insensitive set of names and IDs
+type set map[string]bool
+
+// newSet returns a set of the given entries
+func newSet(entries []string) set {
+	s := set{}
+	for _, e := range entries {
+		s[strings.ToLower(strings.TrimPrefix(e, "#"))] = true
+	}
+	return s
+}
+
+// has returns whether any of the values is in the set
+func (s set) has(values ...string) bool {
+	for _, v := range values {
+		if v != "" && s[strings.ToLower(v)] {
+			return true
+		}
+	}
+	return false
+}
+
+// rules are the compiled allowlists of a cfg.Auth
+type rules struct {
+	channels    set
+	users       set
+	groups      set
+	admins      set
+	adminGroups set
+}
+
+// Policy decides which channels memod listens in and who may create,
+// edit and delete memos. It is safe for concurrent use and can be
+// updated on config reload
+type Policy struct {
+	mu    sync.RWMutex
+	rules rules
+}
+
+// New returns a policy for config
+func New(config cfg.Auth) *Policy {
+	p := &Policy{}
+	p.Update(config)
+	return p
+}
+
+// Update replaces the rules of the policy with config
+func (p *Policy) Update(config cfg.Auth) {
+	r := rules{
+		channels:    newSet(config.Channels),
+		users:       newSet(config.Users),
+		groups:      newSet(config.Groups),
+		admins:      newSet(config.Admins),
+		adminGroups: newSet(config.AdminGroups),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rules = r
+}
+
+// get returns the current rules
+func (p *Policy) get() rules {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.rules
+}
+
+// NeedsGroups returns whether decisions depend on the groups of a user,
+// so services can skip looking them up otherwise
+func (p *Policy) NeedsGroups() bool {
+	r := p.get()
+	return len(r.groups) > 0 || len(r.adminGroups) > 0
+}
+
+// Listens returns whether memod handles messages in the channel. Messages
+// in other channels are ignored without a reply
+func (p *Policy) Listens(s Subject) bool {
+	r := p.get()
+	return len(r.channels) == 0 || r.channels.has(s.ChannelID, s.ChannelName)
+}
+
+// place returns where memos are denied, in the words of source
+func place(source string) string {
+	switch source {
+	case "slack":
+		return "in this workspace"
+	case "discord":
+		return "in this server"
+	}
+	return "here"
+}
+
+// Authorize returns a *DeniedError if the subject may not perform action
+// on a memo written by author, and writes an audit log entry for it.
+// author is ignored when creating memos
+func (p *Policy) Authorize(action Action, s Subject, author string) error {
+	r := p.get()
+
+	var reason string
+	switch action {
+	case ActionCreate:
+		if !r.mayCreate(s) {
+			reason = place(s.Source)
+		}
+	case ActionEdit, ActionDelete:
+		own := author != "" && (strings.EqualFold(author, s.UserID) || strings.EqualFold(author, s.UserName))
+		if own && !r.mayCreate(s) {
+			reason = place(s.Source)
+		} else if !own && !r.isAdmin(s) {
+			reason = "written by someone else"
+		}
+	default:
+		reason = "with an unknown action"
+	}
+
+	if reason == "" {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"audit":   true,
+		"action":  string(action),
+		"source":  s.Source,
+		"user":    s.UserName,
+		"user_id": s.UserID,
+		"channel": s.ChannelName,
+		"chan_id": s.ChannelID,
+		"author":  author,
+	}).Warn("memo action denied")
+
+	return &DeniedError{Action: action, Reason: reason}
+}
+
+// mayCreate returns whether the subject may create memos. Without any
+// user or group allowlist, everyone may
+func (r rules) mayCreate(s Subject) bool {
+	if len(r.users) == 0 && len(r.groups) == 0 {
+		return true
+	}
+	return r.users.has(s.UserID, s.UserName) || r.groups.has(s.Groups...) || r.isAdmin(s)
+}
+
+// isAdmin returns whether the subject may edit and delete any memo
+func (r rules) isAdmin(s Subject) bool {
+	return r.admins.has(s.UserID, s.UserName) || r.adminGroups.has(s.Groups...)
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/grafana/memo/cfg"
+)
+
+func TestAuthorize(t *testing.T) {
+	policy := New(cfg.Auth{
+		Channels:    []string{"#ops", "C0DEV"},
+		Users:       []string{"alice"},
+		Groups:      []string{"sre"},
+		Admins:      []string{"U0ADMIN"},
+		AdminGroups: []string{"leads"},
+	})
+
+	alice := Subject{UserID: "U0ALICE", UserName: "alice", ChannelName: "ops"}
+	bob := Subject{UserID: "U0BOB", UserName: "bob", ChannelName: "ops"}
+	carol := Subject{UserID: "U0CAROL", UserName: "carol", ChannelName: "ops", Groups: []string{"S0SRE", "SRE"}}
+	admin := Subject{UserID: "U0ADMIN", UserName: "dave", ChannelID: "C0DEV"}
+	lead := Subject{UserID: "U0ERIN", UserName: "erin", Groups: []string{"leads"}}
+
+	cases := []struct {
+		action  Action
+		subject Subject
+		author  string
+		allowed bool
+	}{
+		{ActionCreate, alice, "", true},
+		{ActionCreate, bob, "", false},
+		{ActionCreate, carol, "", true},
+		{ActionCreate, admin, "", true},
+		{ActionEdit, alice, "alice", true},
+		{ActionEdit, alice, "bob", false},
+		{ActionDelete, bob, "bob", false},
+		{ActionDelete, admin, "bob", true},
+		{ActionDelete, lead, "alice", true},
+	}
+
+	for i, c := range cases {
+		err := policy.Authorize(c.action, c.subject, c.author)
+		if (err == nil) != c.allowed {
+			t.Errorf("case %d: %s by %s of memo by %q: exp allowed=%t, got err %v", i, c.action, c.subject.UserName, c.author, c.allowed, err)
+		}
+		if err != nil {
+			if _, ok := err.(*DeniedError); !ok {
+				t.Errorf("case %d: exp a *DeniedError, got %T", i, err)
+			}
+		}
+	}
+
+	// the reason is worded for the source
+	bob.Source = "discord"
+	if err := policy.Authorize(ActionCreate, bob, ""); err == nil || err.Error() != "you are not allowed to create memos in this server" {
+		t.Errorf("exp the discord wording, got %v", err)
+	}
+
+	if !policy.Listens(alice) || !policy.Listens(admin) || policy.Listens(lead) {
+		t.Errorf("channel allowlist not applied")
+	}
+
+	policy.Update(cfg.Auth{})
+	if !policy.Listens(lead) || policy.Authorize(ActionCreate, bob, "") != nil {
+		t.Errorf("an empty policy must allow everyone everywhere")
+	}
+}
@@ -1,21 +1,44 @@
 package cfg
 
+import "time"
+
 type Config struct {
 	LogLevel string `toml:"log_level"`
-	Slack    Slack
-	Discord  Discord
-	Grafana  Grafana
+	// ShutdownTimeout is how long to wait for in-flight memos on shutdown
+	ShutdownTimeout Duration `toml:"shutdown_timeout"`
+	Slack           Slack
+	Discord         Discord
+	Grafana         Grafana
+	HTTP            HTTP
+	Auth            Auth
+	RateLimit       RateLimit `toml:"rate_limit"`
+	Dedup           Dedup
+	Tags            Tags
+	Triggers        Triggers
+	Workers         Workers
 }
 
 type Slack struct {
 	Enabled  bool   `toml:"enabled"`
 	BotToken string `toml:"bot_token"`
 	AppToken string `toml:"app_token"`
+	// Reminders posts a reminder to the channel when a future memo starts
+	Reminders bool `toml:"reminders"`
 }
 
 type Discord struct {
 	Enabled  bool   `toml:"enabled"`
 	BotToken string `toml:"bot_token"`
+	// Reminders posts a reminder to the channel when a future memo starts
+	Reminders bool `toml:"reminders"`
+	// Timezones are the time zones of users, by user ID or name, as
+	// discord does not share them
+	Timezones map[string]string `toml:"timezones"`
+	// TimezonesPath is where the time zones users set with "memo tz" are
+	// kept. they are forgotten on restart if empty
+	TimezonesPath string `toml:"timezones_path"`
+	// IDs tags memos with the channel and guild IDs instead of their names
+	IDs bool `toml:"ids"`
 }
 
 type Grafana struct {
@@ -23,4 +46,150 @@ type Grafana struct {
 	ApiUrl  string `toml:"api_url"`
 	TLSKey  string `toml:"tls_key"`
 	TLSCert string `toml:"tls_cert"`
+	// Metadata renders author, channel and source as "tags" (default),
+	// as a "footer" line of the text, or "both"
+	Metadata string `toml:"metadata"`
+	// TextTemplate is a text/template rendering the annotation text from
+	// the memo, e.g. "{{.Desc}} by {{.Author.Name}}: {{.Permalink}}"
+	TextTemplate string `toml:"text_template"`
+	// Timeout of each request to Grafana. defaults to 10s
+	Timeout Duration `toml:"timeout"`
+}
+
+type HTTP struct {
+	// ListenAddr serves /metrics, /healthz and /readyz. disabled if empty
+	ListenAddr string `toml:"listen_addr"`
+}
+
+// Auth configures who may do what, and where. Entries are names or IDs
+type Auth struct {
+	// Channels memod listens in. all channels if empty
+	Channels []string `toml:"channels"`
+	// Users allowed to create memos. everyone if both Users and Groups are empty
+	Users []string `toml:"users"`
+	// Groups are slack user groups or discord roles allowed to create memos
+	Groups []string `toml:"groups"`
+	// Admins may edit and delete memos written by others
+	Admins []string `toml:"admins"`
+	// AdminGroups are slack user groups or discord roles of admins
+	AdminGroups []string `toml:"admin_groups"`
+}
+
+// RateLimit configures token bucket limits on memo creation
+type RateLimit struct {
+	// User limits the memos of each user
+	User Limit `toml:"user"`
+	// Channel limits the memos in each channel
+	Channel Limit `toml:"channel"`
+	// Source limits the memos from each service
+	Source Limit `toml:"source"`
+}
+
+// Limit allows bursts of Burst memos, refilled at one per Every.
+// disabled if either is zero
+type Limit struct {
+	Burst int      `toml:"burst"`
+	Every Duration `toml:"every"`
+}
+
+// Dedup configures the suppression of duplicate memos
+type Dedup struct {
+	// Window in which a memo with the same content is a duplicate.
+	// defaults to 1m
+	Window Duration `toml:"window"`
+	// RedeliveryWindow in which a message with the same ID is a
+	// redelivery. defaults to 1h
+	RedeliveryWindow Duration `toml:"redelivery_window"`
+}
+
+// Workers configures the pool saving memos. memos of a channel are saved
+// in order, by the same worker
+type Workers struct {
+	// Count of memos saved at once. defaults to 4
+	Count int `toml:"count"`
+	// QueueSize is how many memos each worker queues. defaults to 100
+	QueueSize int `toml:"queue_size"`
+	// MaxWait is how long a discord message waits for room in a full queue
+	// before it is dropped. defaults to 1s. slack messages don't wait, they
+	// are redelivered
+	MaxWait Duration `toml:"max_wait"`
+}
+
+// Tags configures the policy for tags given by users
+type Tags struct {
+	// Reserved keys are set by memo only. defaults to memo, author, chan,
+	// guild, source, user, host and dedup
+	Reserved []string `toml:"reserved"`
+	// Normalize lowercases tags and trims the space around keys and values
+	Normalize bool `toml:"normalize"`
+	// AllowedKeys restricts the tag keys users can use. any if empty
+	AllowedKeys []string `toml:"allowed_keys"`
+	// KeyPattern is a regex the tag keys must match in full. any if empty
+	KeyPattern string `toml:"key_pattern"`
+	// MaxTags is the maximum number of user tags per memo. unlimited if 0
+	MaxTags int `toml:"max_tags"`
+	// MaxLength is the maximum length of a tag. unlimited if 0
+	MaxLength int `toml:"max_length"`
+	// Syntax is how tags are written in memos. "classic" (default) takes the
+	// words with a colon at the end of the memo. "rich" also takes #hashtags
+	// and key:"quoted values" anywhere, keeps URLs and words with an escaped
+	// colon (\:) in the text
+	Syntax string `toml:"syntax"`
+	// ChannelDefaults maps channel names or IDs to the tags their memos
+	// get by default. they take precedence over tags given by users
+	ChannelDefaults map[string][]string `toml:"channel_defaults"`
+}
+
+// Triggers configures which messages are memos
+type Triggers struct {
+	// Words start a memo, case-insensitively. "memo" if empty
+	Words []string `toml:"words"`
+	// Mention makes a message starting with an @-mention of the bot a memo
+	Mention bool `toml:"mention"`
+	// Regexes match memos. the memo is the first group of the regex, or
+	// what follows the match if it has no groups
+	Regexes []string `toml:"regexes"`
+	// DefaultOffset is how long before the message the event is assumed to
+	// have happened, if the memo has no timespec. 25s if not set
+	DefaultOffset *Duration `toml:"default_offset"`
+	// Multiple makes each line of a message starting with a trigger its
+	// own memo. the lines after it are its body
+	Multiple bool `toml:"multiple"`
+	// Channels override words, regexes and default_offset by channel name
+	// or ID
+	Channels map[string]ChannelTriggers `toml:"channels"`
+}
+
+// ChannelTriggers overrides the triggers in a channel. Unset fields are
+// inherited
+type ChannelTriggers struct {
+	Words         []string  `toml:"words"`
+	Regexes       []string  `toml:"regexes"`
+	DefaultOffset *Duration `toml:"default_offset"`
+}
+
+// Duration is a time.Duration that can be decoded from a TOML string
+// such as "10s" or "1m30s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Or returns the duration, or def if it is not set
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d.Duration == 0 {
+		return def
+	}
+	return d.Duration
 }
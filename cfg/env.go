@@ -0,0 +1,189 @@
+package cfg
+
+import (
+	"encoding"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables overriding config
+const envPrefix = "MEMO_"
+
+// fileSuffix marks a key or variable that references a file holding the value
+const fileSuffix = "_file"
+
+// textUnmarshaler is used to detect fields that decode from a string
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// resolver sets config fields from secret files and environment variables
+type resolver struct {
+	// raw is the undecoded config file, to find `<key>_file` references
+	raw map[string]interface{}
+	// lookupEnv returns the value of an environment variable
+	lookupEnv func(string) (string, bool)
+	// refs records the `<key>_file` references that were resolved
+	refs map[string]bool
+}
+
+// resolve walks the struct v and overrides each field with the first of:
+//
+//   - environment variable MEMO_<SECTION>_<KEY>
+//   - the contents of the file named by MEMO_<SECTION>_<KEY>_FILE
+//   - the contents of the file named by `<key>_file` in the config file
+//
+// keeping the value decoded from the config file otherwise
+func (r *resolver) resolve(v reflect.Value, path []string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		key := field.Tag.Get("toml")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+
+		fv := v.Field(i)
+		keyPath := append(append([]string(nil), path...), key)
+
+		if fv.Kind() == reflect.Struct && !reflect.PtrTo(fv.Type()).Implements(textUnmarshaler) {
+			err := r.resolve(fv, keyPath)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok, err := r.lookup(keyPath)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		err = setField(fv, value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", strings.Join(keyPath, "."), err)
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the override for the key at keyPath, if any
+func (r *resolver) lookup(keyPath []string) (string, bool, error) {
+	name := envPrefix + strings.ToUpper(strings.Join(keyPath, "_"))
+
+	value, okValue := r.lookupEnv(name)
+	file, okFile := r.lookupEnv(name + strings.ToUpper(fileSuffix))
+	if okValue && okFile {
+		return "", false, fmt.Errorf("both %s and %s%s are set", name, name, strings.ToUpper(fileSuffix))
+	}
+	if okValue {
+		return value, true, nil
+	}
+	if okFile {
+		return readSecret(name+strings.ToUpper(fileSuffix), file)
+	}
+
+	section := r.raw
+	for _, k := range keyPath[:len(keyPath)-1] {
+		section, _ = section[k].(map[string]interface{})
+	}
+	key := keyPath[len(keyPath)-1]
+	ref, ok := section[key+fileSuffix]
+	if !ok {
+		return "", false, nil
+	}
+
+	refName := strings.Join(keyPath, ".") + fileSuffix
+	if _, ok := section[key]; ok {
+		return "", false, fmt.Errorf("both %s and %s are set", strings.Join(keyPath, "."), refName)
+	}
+	r.refs[refName] = true
+	file, ok = ref.(string)
+	if !ok {
+		return "", false, fmt.Errorf("%s must be a string", refName)
+	}
+	return readSecret(refName, file)
+}
+
+// readSecret returns the contents of a secret file, without trailing newline
+func readSecret(name, file string) (string, bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %s", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// setField sets the config field from its string representation
+func setField(fv reflect.Value, value string) error {
+	if fv.Kind() == reflect.Ptr {
+		v := reflect.New(fv.Type().Elem())
+		err := setField(v.Elem(), value)
+		if err != nil {
+			return err
+		}
+		fv.Set(v)
+		return nil
+	}
+
+	if reflect.PtrTo(fv.Type()).Implements(textUnmarshaler) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("can not be set from the environment")
+		}
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("can not be set from the environment")
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+)
+
+// Load decodes the config file, applies the overrides from MEMO_*
+// environment variables and secret files and validates the result.
+// If path is empty, the config is read from the environment only.
+// Unknown keys in the config file are logged
+func Load(path string) (Config, error) {
+	return load(path, Config.Problems)
+}
+
+// LoadCLI is like Load, but only validates the grafana config memo-cli
+// uses. The api_key is not required, as memo-cli may authenticate with a
+// client certificate or through a proxy instead
+func LoadCLI(path string) (Config, error) {
+	return load(path, func(c Config) []string {
+		return c.Grafana.Problems()
+	})
+}
+
+// load is Load, validating with problems
+func load(path string, problems func(Config) []string) (Config, error) {
+	config, undecoded, err := Decode(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	for _, key := range undecoded {
+		log.Warnf("unknown key %q in config file %q", key, path)
+	}
+
+	if p := problems(config); len(p) > 0 {
+		return Config{}, fmt.Errorf("invalid %s: %s", source(path), strings.Join(p, "; "))
+	}
+
+	return config, nil
+}
+
+// Decode decodes the config file and applies the overrides from MEMO_*
+// environment variables and secret files, without validating the
+// result. It returns the keys of the config file that don't match any
+// setting
+func Decode(path string) (Config, []string, error) {
+	return decode(path, os.LookupEnv)
+}
+
+// source describes where the config is read from, for error messages
+func source(path string) string {
+	if path == "" {
+		return "environment"
+	}
+	return fmt.Sprintf("config file %q", path)
+}
+
+// decode is Decode with a custom environment lookup
+func decode(path string, lookupEnv func(string) (string, bool)) (Config, []string, error) {
+	config := Config{
+		LogLevel: "info",
+	}
+	raw := map[string]interface{}{}
+	var md toml.MetaData
+
+	if path != "" {
+		var err error
+		md, err = toml.DecodeFile(path, &config)
+		if err != nil {
+			return Config{}, nil, fmt.Errorf("invalid %s: %s", source(path), err)
+		}
+		_, err = toml.DecodeFile(path, &raw)
+		if err != nil {
+			return Config{}, nil, fmt.Errorf("invalid %s: %s", source(path), err)
+		}
+	}
+
+	r := &resolver{
+		raw:       raw,
+		lookupEnv: lookupEnv,
+		refs:      map[string]bool{},
+	}
+	err := r.resolve(reflect.ValueOf(&config).Elem(), nil)
+	if err != nil {
+		return Config{}, nil, fmt.Errorf("invalid %s: %s", source(path), err)
+	}
+
+	var undecoded []string
+	for _, key := range md.Undecoded() {
+		name := key.String()
+		// secret file references are resolved, not decoded
+		if r.refs[name] {
+			continue
+		}
+		undecoded = append(undecoded, name)
+	}
+
+	return config, undecoded, nil
+}
+
+// Validate returns an error describing every problem that keeps the
+// config from being applied
+func (c Config) Validate() error {
+	problems := c.Problems()
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// Problems lists the problems that keep the config from being applied
+func (c Config) Problems() []string {
+	var problems []string
+
+	_, err := log.ParseLevel(c.LogLevel)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to parse log-level %q: %s", c.LogLevel, err))
+	}
+
+	if c.ShutdownTimeout.Duration < 0 {
+		problems = append(problems, "shutdown_timeout must not be negative")
+	}
+
+	problems = append(problems, c.Grafana.Problems()...)
+	if c.Grafana.ApiKey == "" {
+		problems = append(problems, "grafana.api_key is not set")
+	}
+
+	if c.Tags.KeyPattern != "" {
+		_, err := regexp.Compile(c.Tags.KeyPattern)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid tags.key_pattern %q: %s", c.Tags.KeyPattern, err))
+		}
+	}
+	if c.Tags.MaxTags < 0 || c.Tags.MaxLength < 0 {
+		problems = append(problems, "tags.max_tags and tags.max_length must not be negative")
+	}
+	switch c.Tags.Syntax {
+	case "", "classic", "rich":
+	default:
+		problems = append(problems, fmt.Sprintf("tags.syntax %q must be classic or rich", c.Tags.Syntax))
+	}
+
+	problems = append(problems, c.Triggers.Problems()...)
+
+	if c.Dedup.Window.Duration < 0 || c.Dedup.RedeliveryWindow.Duration < 0 {
+		problems = append(problems, "dedup.window and dedup.redelivery_window must not be negative")
+	}
+
+	if c.Workers.Count < 0 || c.Workers.QueueSize < 0 || c.Workers.MaxWait.Duration < 0 {
+		problems = append(problems, "workers.count, workers.queue_size and workers.max_wait must not be negative")
+	}
+
+	limits := []struct {
+		name  string
+		limit Limit
+	}{
+		{"user", c.RateLimit.User},
+		{"channel", c.RateLimit.Channel},
+		{"source", c.RateLimit.Source},
+	}
+	for _, l := range limits {
+		if l.limit.Burst < 0 || l.limit.Every.Duration < 0 {
+			problems = append(problems, fmt.Sprintf("rate_limit.%s must not be negative", l.name))
+		}
+	}
+
+	if c.Slack.Enabled {
+		if !strings.HasPrefix(c.Slack.BotToken, "xoxb-") {
+			problems = append(problems, "slack is enabled, but slack.bot_token is not a bot token (xoxb-...)")
+		}
+		if !strings.HasPrefix(c.Slack.AppToken, "xapp-") {
+			problems = append(problems, "slack is enabled, but slack.app_token is not an app-level token (xapp-...)")
+		}
+	}
+
+	if c.Discord.Enabled && c.Discord.BotToken == "" {
+		problems = append(problems, "discord is enabled, but discord.bot_token is not set")
+	}
+	var users []string
+	for user := range c.Discord.Timezones {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		_, err := time.LoadLocation(c.Discord.Timezones[user])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid time zone %q for discord user %q: %s", c.Discord.Timezones[user], user, err))
+		}
+	}
+
+	return problems
+}
+
+// Problems lists the problems of the triggers config
+func (t Triggers) Problems() []string {
+	var problems []string
+
+	check := func(section string, regexes []string, offset *Duration) {
+		for _, re := range regexes {
+			_, err := regexp.Compile(re)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("invalid %s.regexes entry %q: %s", section, re, err))
+			}
+		}
+		if offset != nil && offset.Duration < 0 {
+			problems = append(problems, fmt.Sprintf("%s.default_offset must not be negative", section))
+		}
+	}
+
+	check("triggers", t.Regexes, t.DefaultOffset)
+
+	var channels []string
+	for ch := range t.Channels {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+	for _, ch := range channels {
+		c := t.Channels[ch]
+		check(fmt.Sprintf("triggers.channels.%s", ch), c.Regexes, c.DefaultOffset)
+	}
+
+	return problems
+}
+
+// Problems lists the problems of the grafana config. The api_key is only
+// required by memod, see Config.Problems
+func (g Grafana) Problems() []string {
+	var problems []string
+
+	u, err := url.Parse(g.ApiUrl)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to parse grafana.api_url %q: %s", g.ApiUrl, err))
+	} else if !u.IsAbs() || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("grafana.api_url %q must be an absolute URL, like http://localhost/api/", g.ApiUrl))
+	}
+
+	switch g.Metadata {
+	case "", "tags", "footer", "both":
+	default:
+		problems = append(problems, fmt.Sprintf("grafana.metadata %q must be one of tags, footer or both", g.Metadata))
+	}
+
+	if g.TextTemplate != "" {
+		_, err := template.New("text").Parse(g.TextTemplate)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid grafana.text_template: %s", err))
+		}
+	}
+
+	if (g.TLSKey == "") != (g.TLSCert == "") {
+		problems = append(problems, "grafana.tls_key and grafana.tls_cert must be set together")
+	}
+
+	if g.Timeout.Duration < 0 {
+		problems = append(problems, "grafana.timeout must not be negative")
+	}
+
+	return problems
+}
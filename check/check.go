@@ -0,0 +1,157 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/grafana/memo/cfg"
+	discordService "github.com/grafana/memo/service/discord"
+	slackService "github.com/grafana/memo/service/slack"
+	"github.com/grafana/memo/store"
+	"github.com/mitchellh/go-homedir"
+)
+
+// DefaultTimeout bounds each connectivity check
+const DefaultTimeout = 5 * time.Second
+
+// Options for a config check
+type Options struct {
+	// Services also checks the enabled chat services, only memod uses them
+	Services bool
+	// Timeout bounds each connectivity check
+	Timeout time.Duration
+}
+
+// report writes the outcome of each check
+type report struct {
+	w        io.Writer
+	problems int
+}
+
+// ok reports a passed check
+func (r *report) ok(name, format string, args ...interface{}) {
+	fmt.Fprintf(r.w, "[ OK ] %-10s %s\n", name, fmt.Sprintf(format, args...))
+}
+
+// fail reports a failed check
+func (r *report) fail(name, format string, args ...interface{}) {
+	r.problems++
+	fmt.Fprintf(r.w, "[FAIL] %-10s %s\n", name, fmt.Sprintf(format, args...))
+}
+
+// skip reports a check that was not run
+func (r *report) skip(name, format string, args ...interface{}) {
+	fmt.Fprintf(r.w, "[SKIP] %-10s %s\n", name, fmt.Sprintf(format, args...))
+}
+
+// Run decodes the config file strictly, validates it and tries to reach
+// the configured backends and services, writing a readable report to w.
+// It returns the number of problems found
+func Run(w io.Writer, path string, opts Options) int {
+	r := &report{w: w}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	config, undecoded, err := cfg.Decode(path)
+	if err != nil {
+		r.fail("config", "%s", err)
+		return r.problems
+	}
+	if path == "" {
+		r.ok("config", "read from the environment")
+	} else {
+		r.ok("config", "decoded %q", path)
+	}
+
+	for _, key := range undecoded {
+		r.fail("config", "unknown key %q", key)
+	}
+
+	problems := config.Grafana.Problems()
+	if opts.Services {
+		problems = config.Problems()
+	}
+	for _, p := range problems {
+		r.fail("config", "%s", p)
+	}
+	if len(undecoded) == 0 && len(problems) == 0 {
+		r.ok("config", "all settings valid")
+	}
+
+	checkGrafana(r, config.Grafana, opts.Timeout)
+
+	if opts.Services {
+		checkSlack(r, config.Slack, opts.Timeout)
+		checkDiscord(r, config.Discord, opts.Timeout)
+	}
+
+	fmt.Fprintf(w, "\n%d problem(s) found\n", r.problems)
+	return r.problems
+}
+
+// checkGrafana checks that the grafana API can be reached with the key
+func checkGrafana(r *report, config cfg.Grafana, timeout time.Duration) {
+	var err error
+	if config.TLSKey != "" {
+		config.TLSKey, err = homedir.Expand(config.TLSKey)
+	}
+	if err == nil && config.TLSCert != "" {
+		config.TLSCert, err = homedir.Expand(config.TLSCert)
+	}
+	if err != nil {
+		r.fail("grafana", "%s", err)
+		return
+	}
+
+	g, err := store.NewGrafana(config)
+	if err != nil {
+		r.fail("grafana", "%s", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = g.CheckContext(ctx)
+	if err != nil {
+		r.fail("grafana", "%s", err)
+		return
+	}
+	r.ok("grafana", "reachable at %s", config.ApiUrl)
+}
+
+// checkSlack checks the slack tokens, if slack is enabled
+func checkSlack(r *report, config cfg.Slack, timeout time.Duration) {
+	if !config.Enabled {
+		r.skip("slack", "not enabled")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := slackService.Check(ctx, config)
+	if err != nil {
+		r.fail("slack", "%s", err)
+		return
+	}
+	r.ok("slack", "bot and app tokens accepted")
+}
+
+// checkDiscord checks the discord token, if discord is enabled
+func checkDiscord(r *report, config cfg.Discord, timeout time.Duration) {
+	if !config.Enabled {
+		r.skip("discord", "not enabled")
+		return
+	}
+
+	err := discordService.Check(config, timeout)
+	if err != nil {
+		r.fail("discord", "%s", err)
+		return
+	}
+	r.ok("discord", "bot token accepted")
+}
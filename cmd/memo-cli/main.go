@@ -1,15 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/user"
 	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/grafana/memo"
 	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/check"
 	"github.com/grafana/memo/store"
 	"github.com/mitchellh/go-homedir"
 )
@@ -20,19 +21,54 @@ var configFile string
 // timestamp
 var timestamp int
 
+// endTimestamp
+var endTimestamp int
+
 // extraTags
 var extraTags CsvStringVar
 
+// dashboardUID
+var dashboardUID string
+
+// panelID
+var panelID int64
+
 // message
 var message string
 
+// key
+var key string
+
 // main
 func main() {
+	args := os.Args[1:]
+	checkConfig := len(args) > 0 && args[0] == "check-config"
+	if checkConfig {
+		args = args[1:]
+	}
+
 	flag.IntVar(&timestamp, "ts", int(time.Now().Unix()), "unix timestamp. always defaults to 'now'")
+	flag.IntVar(&endTimestamp, "end", 0, "unix timestamp of the end, to submit a region")
+	flag.StringVar(&dashboardUID, "dashboard", "", "UID of the dashboard to limit the annotation to")
+	flag.Int64Var(&panelID, "panel", 0, "ID of the panel of -dashboard to limit the annotation to")
 	flag.Var(&extraTags, "tags", "One or more comma-separated tags to submit, in addition to 'memo', 'user:<unix-username>' and 'host:<hostname>'")
 	flag.StringVar(&message, "msg", "", "message to submit")
+	flag.StringVar(&key, "key", "", "idempotency key, such as a CI job ID. the memo is not submitted again if one with the same key exists")
 	flag.StringVar(&configFile, "config", "~/.memo.toml", "config file location")
-	flag.Parse()
+	flag.CommandLine.Parse(args)
+
+	if checkConfig {
+		path, err := homedir.Expand(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get path to config file (%s): %s\n", configFile, err.Error())
+			os.Exit(2)
+		}
+		problems := check.Run(os.Stdout, path, check.Options{})
+		if problems > 0 {
+			os.Exit(1)
+		}
+		return
+	}
 
 	if message == "" {
 		fmt.Fprintln(os.Stderr, "message cannot be empty")
@@ -57,57 +93,82 @@ func main() {
 		os.Exit(2)
 	}
 
-	var config cfg.Config
-	_, err = toml.DecodeFile(configFile, &config)
+	config, err := cfg.LoadCLI(configFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid config file %q: %s\n", configFile, err.Error())
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(2)
 	}
 
-	var tlsKey string
-	var tlsCert string
 	if config.Grafana.TLSKey != "" {
-		tlsKey, err = homedir.Expand(config.Grafana.TLSKey)
+		tlsKey, err := homedir.Expand(config.Grafana.TLSKey)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to read tls_key (%s): %s\n", config.Grafana.TLSKey, err.Error())
 			os.Exit(2)
 		}
+		config.Grafana.TLSKey = tlsKey
 	}
 	if config.Grafana.TLSCert != "" {
-		tlsCert, err = homedir.Expand(config.Grafana.TLSCert)
+		tlsCert, err := homedir.Expand(config.Grafana.TLSCert)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to read tls_cert (%s): %s\n", config.Grafana.TLSCert, err.Error())
 			os.Exit(2)
 		}
+		config.Grafana.TLSCert = tlsCert
 	}
 
-	store, err := store.NewGrafana(config.Grafana.ApiKey, config.Grafana.ApiUrl, tlsKey, tlsCert)
+	store, err := store.NewGrafana(config.Grafana)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create Grafana store: %s\n", err.Error())
 		os.Exit(2)
 	}
 
-	memo := memo.Memo{
+	tagPolicy, err := memo.NewTagPolicy(config.Tags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(2)
+	}
+
+	userTags, err := tagPolicy.Clean(extraTags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set tags: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	// the user is tagged as user:, as before author: existed. the author
+	// is not set, so it is not tagged twice
+	m := memo.Memo{
 		Date: time.Unix(int64(timestamp), 0),
 		Desc: message,
-	}
 
-	tags := []string{
-		"memo",
-		"user:" + usr.Username,
-		"host:" + hostname,
-		"source:cli",
+		Source: "cli",
+
+		DashboardUID: dashboardUID,
+		PanelID:      panelID,
+	}
+	if endTimestamp != 0 {
+		m.End = time.Unix(int64(endTimestamp), 0)
 	}
 
-	memo.BuildTags(tags)
-	memo.BuildTags(extraTags)
+	m.Tags.AddUser(userTags...)
+	m.Tags.AddSystem("user", usr.Username)
+	m.Tags.AddSystem("host", hostname)
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to set tags: %s\n", err.Error())
-		os.Exit(2)
+	if key != "" {
+		// retried CI jobs submit the same memo again
+		tag := memo.Tag{Key: "dedup", Value: key}
+		saved, err := store.Tagged(tag.String())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to look up memos with key %q: %s\n", key, err.Error())
+			os.Exit(2)
+		}
+		if saved {
+			fmt.Println("memo already saved")
+			return
+		}
+		m.Tags.AddSystem(tag.Key, tag.Value)
 	}
 
-	err = store.Save(memo)
+	err = store.Save(context.Background(), m)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to save memo in store: %s\n", err.Error())
 		os.Exit(2)
@@ -3,10 +3,9 @@ package main
 import (
 	"os"
 
-	"github.com/BurntSushi/toml"
 	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/check"
 	"github.com/grafana/memo/daemon"
-	"github.com/grafana/memo/store"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -14,37 +13,47 @@ var configFile = "/etc/memo.toml"
 
 // main
 func main() {
-	if len(os.Args) > 2 {
-		log.Fatal("usage: memo [path-to-config]")
+	args := os.Args[1:]
+	checkConfig := len(args) > 0 && args[0] == "check-config"
+	if checkConfig {
+		args = args[1:]
 	}
-	if len(os.Args) == 2 {
-		configFile = os.Args[1]
+
+	if len(args) > 1 {
+		log.Fatal("usage: memod [check-config] [path-to-config]")
+	}
+	if len(args) == 1 {
+		configFile = args[0]
+	} else if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		// no config file, configure from MEMO_* environment variables only
+		configFile = ""
 	}
 
-	var config cfg.Config
-	_, err := toml.DecodeFile(configFile, &config)
-	if err != nil {
-		log.Fatalf("Invalid config file %q: %s", configFile, err.Error())
+	if checkConfig {
+		problems := check.Run(os.Stdout, configFile, check.Options{Services: true})
+		if problems > 0 {
+			os.Exit(1)
+		}
+		return
 	}
 
-	lvl, err := log.ParseLevel(config.LogLevel)
+	config, err := cfg.Load(configFile)
 	if err != nil {
-		log.Fatalf("failed to parse log-level %q: %s", config.LogLevel, err.Error())
+		log.Fatalf("%s", err.Error())
 	}
 
+	lvl, _ := log.ParseLevel(config.LogLevel)
 	log.SetLevel(lvl)
 	log.SetOutput(os.Stdout)
 
-	store, err := store.NewGrafana(config.Grafana.ApiKey, config.Grafana.ApiUrl, config.Grafana.TLSKey, config.Grafana.TLSCert)
+	daemon, err := daemon.New(config, configFile)
 	if err != nil {
-		log.Fatalf("failed to create Grafana store: %s", err.Error())
+		log.Fatalf("%s", err.Error())
 	}
-	err = store.Check()
+
+	err = daemon.Run()
 	if err != nil {
-		log.Fatalf("Grafana store is unhealthy: %s", err.Error())
+		log.Errorf("%s", err.Error())
+		os.Exit(1)
 	}
-
-	daemon := daemon.New(config, store)
-
-	daemon.Run()
 }
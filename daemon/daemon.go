@@ -1,66 +1,156 @@
 package daemon
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/grafana/memo"
+	"github.com/grafana/memo/auth"
 	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/dedup"
 	"github.com/grafana/memo/parser"
-	discordService "github.com/grafana/memo/service/discord"
-	slackService "github.com/grafana/memo/service/slack"
+	"github.com/grafana/memo/ratelimit"
+	"github.com/grafana/memo/service"
 	"github.com/grafana/memo/store"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
+// defaultShutdownTimeout is used when shutdown_timeout is not configured
+const defaultShutdownTimeout = 10 * time.Second
+
+// storeCheckTimeout is how long the health check of a new store may take
+const storeCheckTimeout = 10 * time.Second
+
 // Daemon
 type Daemon struct {
-	// store
-	store store.Store
+	// store, swapped when the grafana config is reloaded
+	store *store.Swappable
+	// configFile the config is (re)loaded from
+	configFile string
 	// config
 	config cfg.Config
 	// parser
 	parser parser.Parser
+	// tags is the tag policy of the parser, updated on reload
+	tags *memo.TagPolicy
+	// triggers of the parser, updated on reload
+	triggers *parser.Triggers
+	// policy is shared by the services and updated on reload
+	policy *auth.Policy
+	// limiter is shared by the services and updated on reload
+	limiter *ratelimit.Limiter
+	// dedup is shared by the services and updated on reload
+	dedup *dedup.Filter
+	// pool handles the messages of the services
+	pool *service.Pool
+	// reminders of future memos, kept when services are restarted
+	reminders *service.Reminders
+	// mu protects config and services
+	mu sync.Mutex
+	// services that are running, each under its own supervisor
+	services []*service.Supervisor
+
+	// storeHealth is the result of the last store health check
+	storeHealth storeHealth
+	// httpServer serves the metrics and health endpoints
+	httpServer *http.Server
 }
 
-// New
-func New(config cfg.Config, store store.Store) *Daemon {
+// New returns a daemon for config, which was loaded from configFile. It
+// fails if the store can not be reached
+func New(config cfg.Config, configFile string) (*Daemon, error) {
+	st, err := newStore(config.Grafana)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := memo.NewTagPolicy(config.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	triggers, err := parser.NewTriggers(config.Triggers)
+	if err != nil {
+		return nil, err
+	}
+
 	d := Daemon{
-		store:  store,
-		config: config,
-		parser: parser.New(),
+		store:      store.NewSwappable(st),
+		configFile: configFile,
+		config:     config,
+		parser:     parser.New(),
+		tags:       tags,
+		triggers:   triggers,
+		policy:     auth.New(config.Auth),
+		limiter:    ratelimit.New(config.RateLimit),
+		dedup:      dedup.New(config.Dedup),
+		pool:       service.NewPool(config.Workers),
+		reminders:  &service.Reminders{},
+	}
+
+	d.parser.SetTagPolicy(tags)
+	d.parser.SetTriggers(triggers)
+
+	return &d, nil
+}
+
+// newStore creates the grafana store and checks its health
+func newStore(config cfg.Grafana) (store.Store, error) {
+	grafana, err := store.NewGrafana(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Grafana store: %s", err)
 	}
 
-	return &d
+	ctx, cancel := context.WithTimeout(context.Background(), storeCheckTimeout)
+	defer cancel()
+	err = grafana.CheckContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Grafana store is unhealthy: %s", err)
+	}
+
+	return store.NewInstrumented(grafana, "grafana"), nil
 }
 
-// Run
-func (d *Daemon) Run() {
+// Run starts the services and blocks until the process is signalled to
+// stop. SIGHUP reloads the config file. It returns an error if the
+// shutdown was not clean
+func (d *Daemon) Run() error {
 	log.Info("Memo starting")
 
-	if d.config.Slack.Enabled {
-		log.Info("slack enabled")
-		_, err := slackService.New(
-			d.config.Slack,
-			d.parser,
-			d.store,
-		)
+	prometheus.MustRegister(newServiceCollector(d))
 
-		if err != nil {
-			log.Fatalf("Could not initialise Slack Handler")
-		}
-	}
+	d.storeHealth.set(d.store.Check())
+	stopHealth := make(chan struct{})
+	defer close(stopHealth)
+	go d.storeHealth.watch(d.store, stopHealth)
 
-	if d.config.Discord.Enabled {
-		log.Info("discord enabled")
-		_, err := discordService.New(
-			d.config.Discord,
-			d.parser,
-			d.store,
-		)
+	if d.config.HTTP.ListenAddr != "" {
+		d.httpServer = d.newHTTPServer(d.config.HTTP.ListenAddr)
+		go func() {
+			log.Infof("serving metrics and health on %s", d.config.HTTP.ListenAddr)
+			err := d.httpServer.ListenAndServe()
+			if err != http.ErrServerClosed {
+				log.Fatalf("http server failed: %s", err.Error())
+			}
+		}()
+	}
 
+	for _, name := range serviceNames {
+		svc, err := d.newService(name, d.config)
 		if err != nil {
-			log.Fatalf("could not initialise discord handler")
+			log.Fatalf("could not initialise %s handler: %s", name, err.Error())
+		}
+		if svc != nil {
+			d.mu.Lock()
+			d.services = append(d.services, service.Supervise(svc))
+			d.mu.Unlock()
 		}
 	}
 
@@ -68,9 +158,82 @@ func (d *Daemon) Run() {
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
 
+	var reload = make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
 	// hold the process open until we panic or cancel
-	<-gracefulStop
+	for {
+		select {
+		case <-reload:
+			err := d.Reload()
+			if err != nil {
+				log.Errorf("config reload rejected, keeping the current config: %s", err.Error())
+			}
+		case <-gracefulStop:
+			return d.shutdown()
+		}
+	}
+}
+
+// supervisors returns the supervisors of the running services
+func (d *Daemon) supervisors() []*service.Supervisor {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]*service.Supervisor(nil), d.services...)
+}
+
+// shutdown stops all services in parallel, giving them up to the
+// configured grace period to drain their in-flight memos
+func (d *Daemon) shutdown() error {
+	timeout := d.shutdownTimeout()
+	log.Infof("shutting down, waiting up to %s for in-flight memos", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	services := d.supervisors()
+	errs := make(chan error, len(services))
+	for _, svc := range services {
+		go func(svc *service.Supervisor) {
+			err := svc.Stop(ctx)
+			if err != nil {
+				err = fmt.Errorf("%s: %s", svc.Name(), err)
+			}
+			errs <- err
+		}(svc)
+	}
+
+	failed := 0
+	for range services {
+		err := <-errs
+		if err != nil {
+			log.Errorf("unclean shutdown of %s", err)
+			failed++
+		}
+	}
+
+	// the services are drained, so nothing is queued anymore unless they
+	// timed out
+	err := d.pool.Stop(ctx)
+	if err != nil {
+		log.Errorf("unclean shutdown of worker pool: %s", err)
+		failed++
+	}
+	d.reminders.Stop()
+
+	if d.httpServer != nil {
+		err := d.httpServer.Shutdown(ctx)
+		if err != nil {
+			log.Errorf("unclean shutdown of http server: %s", err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d component(s) did not shut down cleanly", failed)
+	}
 
-	log.Info("shutting down")
-	os.Exit(0)
+	log.Info("shutdown complete")
+	return nil
 }
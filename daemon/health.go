@@ -0,0 +1,160 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/memo/metrics"
+	"github.com/grafana/memo/service"
+	"github.com/grafana/memo/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// storeCheckInterval is how often the store health is checked
+const storeCheckInterval = 30 * time.Second
+
+// storeHealth keeps the result of the last store health check, so the
+// health endpoints don't hit the store on every probe
+type storeHealth struct {
+	mu  sync.Mutex
+	err error
+}
+
+// set records the result of a health check
+func (h *storeHealth) set(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.err = err
+	if err != nil {
+		metrics.StoreUp.Set(0)
+	} else {
+		metrics.StoreUp.Set(1)
+	}
+}
+
+// get returns the result of the last health check
+func (h *storeHealth) get() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.err
+}
+
+// watch checks the store periodically until stop is closed
+func (h *storeHealth) watch(store store.Store, stop chan struct{}) {
+	ticker := time.NewTicker(storeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := store.Check()
+			if err != nil {
+				log.Errorf("store is unhealthy: %s", err.Error())
+			}
+			h.set(err)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// serviceCollector exports the connection state and reconnect count of
+// the supervised services
+type serviceCollector struct {
+	d *Daemon
+
+	state      *prometheus.Desc
+	reconnects *prometheus.Desc
+}
+
+// newServiceCollector returns a collector for the services of d
+func newServiceCollector(d *Daemon) *serviceCollector {
+	return &serviceCollector{
+		d: d,
+		state: prometheus.NewDesc(
+			"memo_service_state",
+			"Connection state of a chat service, 1 for the current state.",
+			[]string{"service", "state"}, nil,
+		),
+		reconnects: prometheus.NewDesc(
+			"memo_service_reconnects_total",
+			"Restarts of a chat service after its connection failed.",
+			[]string{"service"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *serviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.reconnects
+}
+
+// Collect implements prometheus.Collector
+func (c *serviceCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, svc := range c.d.supervisors() {
+		current := svc.State()
+		for _, state := range []service.State{service.StateConnecting, service.StateConnected, service.StateFailed, service.StateStopped} {
+			v := 0.0
+			if state == current {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, v, svc.Name(), state.String())
+		}
+		ch <- prometheus.MustNewConstMetric(c.reconnects, prometheus.CounterValue, float64(svc.Reconnects()), svc.Name())
+	}
+}
+
+// newHTTPServer returns the server for the metrics and health endpoints
+func (d *Daemon) newHTTPServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", d.handleHealth(false))
+	mux.HandleFunc("/readyz", d.handleHealth(true))
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}
+
+// handleHealth reports the state of each service. A failed service makes
+// the process unhealthy. For readiness, the store must also be healthy and
+// every service connected. A store outage is not fixed by restarting memod,
+// so it does not affect liveness
+func (d *Daemon) handleHealth(ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var lines []string
+		ok := true
+
+		if ready {
+			err := d.storeHealth.get()
+			if err != nil {
+				ok = false
+				lines = append(lines, fmt.Sprintf("store: %s", err.Error()))
+			} else {
+				lines = append(lines, "store: ok")
+			}
+		}
+
+		for _, svc := range d.supervisors() {
+			state := svc.State()
+			if state == service.StateFailed || (ready && state != service.StateConnected) {
+				ok = false
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", svc.Name(), state))
+		}
+
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, strings.Join(lines, "\n"))
+	}
+}
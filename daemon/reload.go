@@ -0,0 +1,219 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/grafana/memo"
+	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/parser"
+	"github.com/grafana/memo/service"
+	discordService "github.com/grafana/memo/service/discord"
+	slackService "github.com/grafana/memo/service/slack"
+	"github.com/grafana/memo/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// serviceNames lists the chat services in the order they are started
+var serviceNames = []string{"slack", "discord"}
+
+// newService creates the named service from config, or returns nil if it
+// is not enabled
+func (d *Daemon) newService(name string, config cfg.Config) (service.Service, error) {
+	switch name {
+	case "slack":
+		if !config.Slack.Enabled {
+			return nil, nil
+		}
+		log.Info("slack enabled")
+		return slackService.New(config.Slack, d.deps())
+	case "discord":
+		if !config.Discord.Enabled {
+			return nil, nil
+		}
+		log.Info("discord enabled")
+		return discordService.New(config.Discord, d.deps())
+	}
+	return nil, fmt.Errorf("unknown service %q", name)
+}
+
+// deps returns the components shared by the services
+func (d *Daemon) deps() service.Deps {
+	return service.Deps{
+		Parser:  d.parser,
+		Store:   d.store,
+		Policy:  d.policy,
+		Limiter: d.limiter,
+		Dedup:   d.dedup,
+		Tags:    d.tags,
+		Pool:    d.pool,
+
+		Reminders: d.reminders,
+	}
+}
+
+// serviceConfig returns the part of config that affects the named service
+func serviceConfig(name string, config cfg.Config) interface{} {
+	switch name {
+	case "slack":
+		return config.Slack
+	case "discord":
+		return config.Discord
+	}
+	return nil
+}
+
+// currentConfig returns the config in use
+func (d *Daemon) currentConfig() cfg.Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.config
+}
+
+// shutdownTimeout returns the grace period for stopping services
+func (d *Daemon) shutdownTimeout() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.config.ShutdownTimeout.Or(defaultShutdownTimeout)
+}
+
+// Reload re-reads and validates the config file and applies it. Only the
+// services and stores whose config changed are restarted. If the new
+// config is invalid, or a new store or service can not be created, the
+// current config stays in place
+func (d *Daemon) Reload() error {
+	log.Infof("reloading config file %q", d.configFile)
+
+	config, err := cfg.Load(d.configFile)
+	if err != nil {
+		return err
+	}
+
+	// checking a new store can take a while, so it is done without holding
+	// d.mu. reloads don't run concurrently, so d.config stays the same
+	var st store.Store
+	if !reflect.DeepEqual(d.currentConfig().Grafana, config.Grafana) {
+		st, err = newStore(config.Grafana)
+		if err != nil {
+			return err
+		}
+	}
+
+	restart, timeout, err := d.apply(config, st)
+	if err != nil {
+		return err
+	}
+
+	// stopping a service can take up to the shutdown timeout, so it is done
+	// without holding d.mu, which the health and metrics endpoints need
+	for name, svc := range restart {
+		d.restartService(name, svc, timeout)
+	}
+
+	log.Info("config reloaded")
+	return nil
+}
+
+// apply validates config and applies it to the shared components, swapping
+// in st if it is not nil. It returns the services to restart, with the grace
+// period for stopping the running ones. Nothing is changed if it returns an
+// error
+func (d *Daemon) apply(config cfg.Config, st store.Store) (map[string]service.Service, time.Duration, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old := d.config
+
+	// prepare everything that can fail before changing anything
+	_, err := memo.NewTagPolicy(config.Tags)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	_, err = parser.NewTriggers(config.Triggers)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	restart := map[string]service.Service{}
+	for _, name := range serviceNames {
+		if reflect.DeepEqual(serviceConfig(name, old), serviceConfig(name, config)) {
+			continue
+		}
+		svc, err := d.newService(name, config)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not initialise %s handler: %s", name, err)
+		}
+		restart[name] = svc
+	}
+
+	if old.HTTP != config.HTTP {
+		log.Warnf("http config changed, restart memod to apply it")
+	}
+	if old.Workers != config.Workers {
+		log.Warnf("workers config changed, restart memod to apply it")
+	}
+
+	// apply
+	lvl, _ := log.ParseLevel(config.LogLevel)
+	log.SetLevel(lvl)
+
+	if st != nil {
+		log.Info("grafana config changed, swapping store")
+		d.store.Swap(st)
+	}
+
+	d.policy.Update(config.Auth)
+	d.limiter.Update(config.RateLimit)
+	d.dedup.Update(config.Dedup)
+	d.tags.Update(config.Tags)
+	d.triggers.Update(config.Triggers)
+
+	d.config = config
+
+	return restart, old.ShutdownTimeout.Or(defaultShutdownTimeout), nil
+}
+
+// restartService stops the running service with the given name, if any,
+// and starts svc in its place, if not nil. d.mu must not be held, it is
+// only taken to look up and replace the service, not while stopping it
+func (d *Daemon) restartService(name string, svc service.Service, timeout time.Duration) {
+	d.mu.Lock()
+	var old *service.Supervisor
+	for _, sup := range d.services {
+		if sup.Name() == name {
+			old = sup
+		}
+	}
+	d.mu.Unlock()
+
+	if old != nil {
+		log.Infof("%s config changed, stopping service", name)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := old.Stop(ctx)
+		cancel()
+		if err != nil {
+			log.Errorf("unclean stop of %s: %s", name, err.Error())
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var services []*service.Supervisor
+	for _, sup := range d.services {
+		if sup != old {
+			services = append(services, sup)
+		}
+	}
+	if svc != nil {
+		log.Infof("starting %s service", name)
+		services = append(services, service.Supervise(svc))
+	}
+
+	d.services = services
+}
@@ -0,0 +1,146 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/memo/cfg"
+)
+
+// DefaultWindow is used when no window is configured
+const DefaultWindow = time.Minute
+
+// DefaultRedeliveryWindow is used when no redelivery window is configured.
+// chat services may redeliver a message long after the content window
+const DefaultRedeliveryWindow = time.Hour
+
+// Key identifies a memo for duplicate detection
+type Key struct {
+	// ID is the source message ID, e.g. the slack client_msg_id or ts,
+	// or the discord message ID. Empty if the source has none
+	ID string
+	// Content is a hash of the memo's source, channel, author and text
+	Content string
+}
+
+// NewKey returns the key of a memo
+func NewKey(source, messageID, channel, user, text string) Key {
+	k := Key{}
+	if messageID != "" {
+		k.ID = source + "/" + channel + "/" + messageID
+	}
+
+	h := sha256.Sum256([]byte(strings.Join([]string{source, channel, user, strings.TrimSpace(text)}, "\x00")))
+	k.Content = hex.EncodeToString(h[:])
+	return k
+}
+
+// DuplicateError is returned when a memo was already seen
+type DuplicateError struct {
+	// Redelivery is true when the very same message was delivered again,
+	// rather than the same content being posted twice
+	Redelivery bool
+	// Age is how long ago the original was seen
+	Age time.Duration
+}
+
+// Error implements error
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("same memo as %s ago, not saved again", e.Age.Round(time.Second))
+}
+
+// Filter suppresses duplicate memos seen within a window. It keys on the
+// source message ID, kept for the longer redelivery window, and falls back
+// to the content of the memo. It is safe for concurrent use
+type Filter struct {
+	mu     sync.Mutex
+	window time.Duration
+	// redelivery is how long the message IDs are kept
+	redelivery time.Duration
+	// seen maps the IDs and content hashes to when they were claimed
+	seen map[string]time.Time
+
+	// for mocking times in tests
+	clock clock.Clock
+}
+
+// New returns a filter for config
+func New(config cfg.Dedup) *Filter {
+	f := &Filter{
+		seen:  map[string]time.Time{},
+		clock: clock.New(),
+	}
+	f.Update(config)
+	return f
+}
+
+// SetClock allows injection of a benbjohnson/clock clock.Clock
+// interface, for mocking within tests.
+func (f *Filter) SetClock(clock clock.Clock) {
+	f.clock = clock
+}
+
+// Update replaces the windows with the ones in config
+func (f *Filter) Update(config cfg.Dedup) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.window = config.Window.Or(DefaultWindow)
+	f.redelivery = config.RedeliveryWindow.Or(DefaultRedeliveryWindow)
+}
+
+// Claim returns a *DuplicateError if a memo with the same ID or content
+// was claimed within the window. Otherwise it records the memo, which
+// must be released with Release if it could not be saved
+func (f *Filter) Claim(k Key) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.clock.Now()
+	f.expire(now)
+
+	if k.ID != "" {
+		if at, ok := f.seen["id:"+k.ID]; ok {
+			return &DuplicateError{Redelivery: true, Age: now.Sub(at)}
+		}
+	}
+	if at, ok := f.seen["content:"+k.Content]; ok {
+		return &DuplicateError{Age: now.Sub(at)}
+	}
+
+	if k.ID != "" {
+		f.seen["id:"+k.ID] = now
+	}
+	f.seen["content:"+k.Content] = now
+	return nil
+}
+
+// Release forgets a claimed memo, so a retry is not suppressed
+func (f *Filter) Release(k Key) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if k.ID != "" {
+		delete(f.seen, "id:"+k.ID)
+	}
+	delete(f.seen, "content:"+k.Content)
+}
+
+// expire removes the content entries older than the window, and the ID
+// entries older than the redelivery window
+func (f *Filter) expire(now time.Time) {
+	for key, at := range f.seen {
+		window := f.window
+		if strings.HasPrefix(key, "id:") {
+			window = f.redelivery
+		}
+		if now.Sub(at) > window {
+			delete(f.seen, key)
+		}
+	}
+}
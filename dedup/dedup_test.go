@@ -0,0 +1,60 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/memo/cfg"
+)
+
+func TestClaim(t *testing.T) {
+	mock := clock.NewMock()
+
+	f := New(cfg.Dedup{Window: cfg.Duration{Duration: 30 * time.Second}})
+	f.SetClock(mock)
+
+	first := NewKey("slack", "1700000000.000100", "C1", "U1", "memo restarted db")
+	redelivered := first
+	retyped := NewKey("slack", "1700000005.000100", "C1", "U1", "memo restarted db ")
+	otherChannel := NewKey("slack", "1700000006.000100", "C2", "U1", "memo restarted db")
+
+	if err := f.Claim(first); err != nil {
+		t.Fatalf("first claim: unexpected error %v", err)
+	}
+
+	mock.Add(5 * time.Second)
+	err := f.Claim(redelivered)
+	if dup, ok := err.(*DuplicateError); !ok || !dup.Redelivery || dup.Age != 5*time.Second {
+		t.Errorf("redelivery: expected a redelivery 5s old, got %v", err)
+	}
+
+	err = f.Claim(retyped)
+	if dup, ok := err.(*DuplicateError); !ok || dup.Redelivery {
+		t.Errorf("same content: expected a content duplicate, got %v", err)
+	}
+
+	if err := f.Claim(otherChannel); err != nil {
+		t.Errorf("other channel: unexpected error %v", err)
+	}
+
+	mock.Add(time.Minute)
+	if err := f.Claim(retyped); err != nil {
+		t.Errorf("after the window: unexpected error %v", err)
+	}
+
+	// redeliveries are recognised for longer than the content window
+	err = f.Claim(redelivered)
+	if dup, ok := err.(*DuplicateError); !ok || !dup.Redelivery {
+		t.Errorf("late redelivery: expected a redelivery, got %v", err)
+	}
+	mock.Add(time.Hour)
+	if err := f.Claim(redelivered); err != nil {
+		t.Errorf("after the redelivery window: unexpected error %v", err)
+	}
+
+	f.Release(retyped)
+	if err := f.Claim(retyped); err != nil {
+		t.Errorf("after release: unexpected error %v", err)
+	}
+}
@@ -8,11 +8,12 @@ require (
 	github.com/benbjohnson/clock v1.0.3
 	github.com/bitly/go-hostpool v0.1.0 // indirect
 	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869 // indirect
-	github.com/bwmarrin/discordgo v0.26.1 // indirect
+	github.com/bwmarrin/discordgo v0.26.1
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/kr/pretty v0.2.1 // indirect
 	github.com/mattbaird/elastigo v0.0.0-20170123220020-2fe47fd29e4b
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/prometheus/client_golang v1.9.0
 	github.com/raintank/dur v0.0.0-20181019115741-955e3a77c6a8
 	github.com/sirupsen/logrus v1.6.0
 	github.com/slack-go/slack v0.11.3
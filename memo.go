@@ -2,7 +2,6 @@ package memo
 
 import (
 	"errors"
-	"sort"
 	"time"
 )
 
@@ -16,22 +15,62 @@ var HelpMessage = "Hi. I only support memo requests. See https://github.com/graf
 type Memo struct {
 	// Date
 	Date time.Time
+	// End of the memo if it covers a time range, zero otherwise
+	End time.Time
 	// Desc
 	Desc string
+	// Body is the text after the first line of the memo, as written
+	Body string
 	// Tags
-	Tags []string
+	Tags TagSet
+
+	// Author who wrote the memo
+	Author Author
+	// Source is the service the memo came from, e.g. "slack" or "cli"
+	Source string
+	// Channel the memo was written in, if any
+	Channel Channel
+	// MessageID identifies the chat message the memo was written in
+	MessageID string
+	// Permalink links to the chat message the memo was written in
+	Permalink string
+
+	// DashboardUID limits the annotation to a dashboard, if set
+	DashboardUID string
+	// PanelID limits the annotation to a panel of the dashboard, if set
+	PanelID int64
 }
 
-// BuildTags takes the base tags (hardcoded), and extra tags (user specified)
-// it validates the user is not trying to override the built in tags,
-// merges them and sorts them
-func (m *Memo) BuildTags(extra []string) {
-	base := []string{
-		"memo",
-	}
+// Author of a memo
+type Author struct {
+	// ID of the user in the source service
+	ID string
+	// Name to display
+	Name string
+}
 
-	base = append(base, extra...)
-	sort.Strings(base)
+// Channel a memo was written in
+type Channel struct {
+	// ID of the channel in the source service
+	ID string
+	// Name to display
+	Name string
+}
 
-	m.Tags = base
+// DisplayName returns the name of the channel, or its ID if the name is
+// not known
+func (c Channel) DisplayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.ID
+}
+
+// DisplayName returns the name of the author, or their ID if the name is
+// not known
+func (a Author) DisplayName() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.ID
 }
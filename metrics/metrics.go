@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "memo"
+
+var (
+	// MessagesReceived counts the chat messages seen by a service
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Chat messages received, by source and channel.",
+	}, []string{"source", "channel"})
+
+	// MemosParsed counts the messages successfully parsed into a memo
+	MemosParsed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "memos_parsed_total",
+		Help:      "Messages parsed into a memo, by source and channel.",
+	}, []string{"source", "channel"})
+
+	// MemosRejected counts the memos that were not saved, by reason
+	MemosRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "memos_rejected_total",
+		Help:      "Memos that were rejected, by source, channel and reason.",
+	}, []string{"source", "channel", "reason"})
+
+	// MemosSaved counts the memos stored successfully
+	MemosSaved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "memos_saved_total",
+		Help:      "Memos saved in the store, by source and channel.",
+	}, []string{"source", "channel"})
+
+	// RateLimited counts the memos rejected by a rate limit
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Memos rejected by a rate limit, by source and the scope of the limit.",
+	}, []string{"source", "scope"})
+
+	// StoreDuration observes the latency of store operations
+	StoreDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_duration_seconds",
+		Help:      "Latency of store operations, by store, operation and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"store", "operation", "result"})
+
+	// StoreUp reports the result of the last store health check
+	StoreUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "store_up",
+		Help:      "Whether the last store health check succeeded.",
+	})
+
+	// OutboxDepth is the number of memos queued, being saved and replied to
+	OutboxDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_depth",
+		Help:      "Memos in flight, queued or waiting to be saved and replied to, by source.",
+	}, []string{"source"})
+
+	// QueueWait observes how long messages wait for a worker
+	QueueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_wait_seconds",
+		Help:      "Time messages wait in the queue for a worker, by source.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"source"})
+
+	// MessagesDropped counts messages dropped because the queue was full
+	MessagesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dropped_total",
+		Help:      "Messages dropped because the queue stayed full, by source. Slack redelivers them.",
+	}, []string{"source"})
+)
+
+// Reasons a memo is rejected, used as MemosRejected label
+const (
+	ReasonParse       = "parse"
+	ReasonDenied      = "denied"
+	ReasonRateLimited = "rate_limited"
+	ReasonDuplicate   = "duplicate"
+	ReasonStore       = "store"
+)
+
+// Result returns the result label for an operation that returned err
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorKind is the kind of a ParseError
+type ErrorKind int
+
+const (
+	// UnknownCommand is a message addressing the bot that is not a memo
+	UnknownCommand ErrorKind = iota + 1
+	// BadTimespec is a timespec that could not be understood
+	BadTimespec
+	// InvalidTag is a tag that violates the tag policy or syntax
+	InvalidTag
+	// TimeInFuture is a time after the message, not written as a future memo
+	TimeInFuture
+	// TimeTooOld is a time too long before the message
+	TimeTooOld
+)
+
+// String returns the name of the kind
+func (k ErrorKind) String() string {
+	switch k {
+	case UnknownCommand:
+		return "unknown command"
+	case BadTimespec:
+		return "bad timespec"
+	case InvalidTag:
+		return "invalid tag"
+	case TimeInFuture:
+		return "time in future"
+	case TimeTooOld:
+		return "time too old"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseError is returned for a message addressing the bot that could not be
+// parsed. Services render it into a reply with the suggestion
+type ParseError struct {
+	// Kind of error
+	Kind ErrorKind
+	// Token is the offending part of the message
+	Token string
+	// Pos is the byte offset of Token in the message, or -1 if it is not
+	// known
+	Pos int
+	// Reason explains what is wrong
+	Reason string
+	// Suggestion is the message corrected, empty if there is none
+	Suggestion string
+
+	// fix replaces Token in the suggestion, if hasFix is set
+	fix    string
+	hasFix bool
+	// end is the byte offset after Token in the message
+	end int
+}
+
+// Error implements error
+func (e *ParseError) Error() string {
+	return e.Reason
+}
+
+// Is makes errors.Is match a *ParseError of the same kind
+func (e *ParseError) Is(target error) bool {
+	t, ok := target.(*ParseError)
+	return ok && t.Kind == e.Kind
+}
+
+// parseError returns a ParseError without suggestion
+func parseError(kind ErrorKind, token string, format string, args ...interface{}) *ParseError {
+	return &ParseError{Kind: kind, Token: token, Pos: -1, Reason: fmt.Sprintf(format, args...)}
+}
+
+// withFix sets what replaces the token in the suggestion. An empty fix
+// removes the token
+func (e *ParseError) withFix(fix string) *ParseError {
+	e.fix = fix
+	e.hasFix = true
+	return e
+}
+
+// at sets the position of the token in the message, unless it is set
+// already by a part of the parser that knows better
+func (e *ParseError) at(pos int) *ParseError {
+	if e.Pos < 0 {
+		e.Pos = pos
+		e.end = pos + len(e.Token)
+	}
+	return e
+}
+
+// shift moves the position of the token by n, for errors in a part of the
+// message
+func (e *ParseError) shift(n int) {
+	if e.Pos >= 0 {
+		e.Pos += n
+		e.end += n
+	}
+}
+
+// locate sets the suggestion, the message text with the token at its
+// position replaced by the fix. The space in the fixed line is tidied up,
+// the other lines are kept as they are
+func (e *ParseError) locate(text string) *ParseError {
+	if e.Pos < 0 || e.end > len(text) || !e.hasFix {
+		return e
+	}
+
+	start := strings.LastIndex(text[:e.Pos], "\n") + 1
+	end := len(text)
+	if i := strings.Index(text[e.end:], "\n"); i >= 0 {
+		end = e.end + i
+	}
+	line := strings.Join(strings.Fields(text[start:e.Pos]+e.fix+text[e.end:end]), " ")
+	e.Suggestion = strings.TrimSpace(text[:start] + line + text[end:])
+	return e
+}
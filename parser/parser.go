@@ -1,92 +1,288 @@
 package parser
 
 import (
-	"errors"
-	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/benbjohnson/clock"
 	"github.com/grafana/memo"
 	"github.com/raintank/dur"
-
-	log "github.com/sirupsen/logrus"
 )
 
 // Parser
 type Parser struct {
-	// re
-	re *regexp.Regexp
+	// triggers decide which messages are memos
+	triggers *Triggers
+
+	// tags validates the tags given by users
+	tags *memo.TagPolicy
+
+	// loc is the time zone of clock times without a zone
+	loc *time.Location
 
 	// for mocking times in tests
 	clock clock.Clock
 }
 
-// Parse takes a message and returns a memo with the fields extracted
+// Parse takes a message and returns a memo with the fields extracted,
+// resolving times relative to now
 func (p *Parser) Parse(message string) (*memo.Memo, error) {
-	message = strings.TrimSpace(message)
+	return p.ParseAt(message, p.clock.Now())
+}
 
-	if len(message) == 0 {
-		return nil, memo.ErrEmpty
-	}
+// ParseAt takes a message and returns a memo with the fields extracted,
+// resolving times relative to reference, the time the message was written
+func (p *Parser) ParseAt(message string, reference time.Time) (*memo.Memo, error) {
+	return p.ParseIn(message, reference, nil)
+}
 
-	m := memo.Memo{}
+// ParseIn is like ParseAt, but clock times without a zone are in loc, the
+// time zone of the user. UTC is used if loc is nil
+func (p *Parser) ParseIn(message string, reference time.Time, loc *time.Location) (*memo.Memo, error) {
+	return p.ParseMessage(Message{Text: message, Time: reference, Location: loc})
+}
+
+// Message is a chat message to parse
+type Message struct {
+	// Text of the message
+	Text string
+	// Time the message was written. now if zero
+	Time time.Time
+	// Location is the time zone of the author. UTC if nil
+	Location *time.Location
+	// Channels are the name and ID of the channel, for its triggers
+	Channels []string
+	// Mentions are how the bot can be @-mentioned
+	Mentions []string
+}
 
-	// does not detect "isForUs" validly
-	ok, err := p.isForUs(message)
+// ParseMessage takes a message and returns a memo with the fields
+// extracted, or nil if the message is not a memo. Messages addressing the
+// bot that can not be parsed return a *ParseError
+func (p *Parser) ParseMessage(msg Message) (*memo.Memo, error) {
+	m, err := p.parse(msg)
+	if perr, ok := err.(*ParseError); ok {
+		perr.locate(msg.Text)
+	}
+	return m, err
+}
+
+// parse does the work of ParseMessage
+func (p *Parser) parse(msg Message) (*memo.Memo, error) {
+	loc := msg.Location
+	if loc == nil {
+		loc = p.loc
+	}
+	reference := msg.Time
+	if reference.IsZero() {
+		reference = p.clock.Now()
+	}
 
-	// regex match fail
+	text, pos, ok, err := p.trigger(msg)
 	if err != nil {
 		return nil, err
 	}
-
-	// not for us
 	if !ok {
 		return nil, nil
 	}
 
-	words := strings.Fields(message)
+	m := memo.Memo{}
+
+	summary, body := splitBody(text)
+	m.Body = body
+
+	words, offsets := fields(summary, pos)
 	if len(words) == 0 {
 		return nil, memo.ErrEmpty
 	}
 
-	// [1:] strips out the "memo" trigger
-	words, ts := p.extractTimestamp(words[1:])
+	rest, ts, end, err := p.extractTimestamp(words, reference, loc, p.triggers.Offset(msg.Channels...))
+	if perr, ok := err.(*ParseError); ok {
+		// the timespec is at the start of the summary
+		if i := strings.Index(summary, perr.Token); i >= 0 && perr.Token != "" {
+			perr.at(pos + i)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) == 0 {
+		return nil, memo.ErrEmpty
+	}
+	offsets = offsets[len(words)-len(rest):]
+	words = rest
 
 	m.Date = ts
-	m.Desc = strings.Join(words, " ")
+	m.End = end
+	var tags []typedTag
+	if p.tags.RichSyntax() {
+		m.Desc, tags, err = richTags(summary[offsets[0]-pos:], offsets[0])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		m.Desc, tags = classicTags(words, offsets)
+	}
+
+	extraTags, err := p.tags.Clean(tagNames(tags))
+	if te, ok := err.(*memo.TagError); ok {
+		typed := tags[te.Index]
+		return nil, parseError(InvalidTag, typed.token, "%s", te.Error()).withFix("").at(typed.pos)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Tags.AddUser(extraTags...)
 
-	pos := len(words) - 1 // pos of the last word that is not a tag
-	for strings.Contains(words[pos], ":") {
-		pos--
-		if pos < 0 {
-			return &m, nil
+	return &m, nil
+}
+
+// ParseAll takes a message and returns the memos in it. That is one memo,
+// like ParseMessage, unless multiple memos per message are enabled in the
+// triggers. Then each line starting with a trigger is a memo, with the
+// lines after it as its body. If any of them fails to parse, none are
+// returned
+func (p *Parser) ParseAll(msg Message) ([]*memo.Memo, error) {
+	if !p.triggers.Multiple() {
+		m, err := p.ParseMessage(msg)
+		if m == nil {
+			return nil, err
 		}
+		return []*memo.Memo{m}, err
 	}
 
-	extraTags := words[pos+1:]
-	m.BuildTags(extraTags)
+	parts, offsets := p.split(msg)
+	if len(parts) == 0 {
+		return nil, nil
+	}
 
-	m.Desc = strings.Join(words[:pos+1], " ")
+	var memos []*memo.Memo
+	for i, part := range parts {
+		m, err := p.parse(part)
+		if err == memo.ErrEmpty {
+			continue
+		}
+		if perr, ok := err.(*ParseError); ok {
+			// the position is in the part, the suggestion is for the
+			// whole message
+			perr.shift(offsets[i])
+			perr.locate(msg.Text)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			memos = append(memos, m)
+		}
+	}
+	if len(memos) == 0 {
+		return nil, memo.ErrEmpty
+	}
+	return memos, nil
+}
 
-	return &m, nil
+// split splits a message into the messages starting at each line with a
+// trigger, and returns them with their byte offsets in the message. Lines
+// before the first trigger are dropped
+func (p *Parser) split(msg Message) ([]Message, []int) {
+	var parts []Message
+	var offsets []int
+	offset := 0
+	for _, line := range strings.Split(msg.Text, "\n") {
+		lineOffset := offset
+		offset += len(line) + 1
+		if strings.TrimSpace(line) != "" {
+			_, ok, err := p.Trigger(Message{Text: line, Channels: msg.Channels, Mentions: msg.Mentions})
+			if ok || err != nil {
+				part := msg
+				part.Text = line
+				parts = append(parts, part)
+				offsets = append(offsets, lineOffset)
+				continue
+			}
+		}
+		if len(parts) > 0 {
+			parts[len(parts)-1].Text += "\n" + line
+		}
+	}
+	return parts, offsets
 }
 
-// isForUs returns if this message has been identified as a memo
-func (p *Parser) isForUs(message string) (bool, error) {
-	out := p.re.FindStringSubmatch(message)
-	if len(out) == 0 {
-		if strings.HasPrefix(message, "memo:") || strings.HasPrefix(message, "mrbot:") || strings.HasPrefix(message, "memobot:") {
-			log.Debugf("A user seems to direct a message `%q` to us, but we don't understand it. so sending help message back", message)
-			return false, errors.New("message could not be understood")
+// fields splits text, at byte offset base in the message, into words
+// around white space. It returns the words with their offsets in the
+// message
+func fields(text string, base int) ([]string, []int) {
+	var words []string
+	var offsets []int
+	start := -1
+	for i, r := range text {
+		switch {
+		case unicode.IsSpace(r) && start >= 0:
+			words = append(words, text[start:i])
+			offsets = append(offsets, base+start)
+			start = -1
+		case !unicode.IsSpace(r) && start < 0:
+			start = i
 		}
+	}
+	if start >= 0 {
+		words = append(words, text[start:])
+		offsets = append(offsets, base+start)
+	}
+	return words, offsets
+}
+
+// splitBody splits text into its first line, the summary, and the lines
+// after it, the body. Blank lines around the body are removed, the
+// indentation of its first line is kept
+func splitBody(text string) (string, string) {
+	i := strings.Index(text, "\n")
+	if i < 0 {
+		return text, ""
+	}
+
+	lines := strings.Split(strings.TrimRight(text[i+1:], " \t\r\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	return text[:i], strings.Join(lines, "\n")
+}
+
+// Trigger returns the text of the message after the trigger, and whether
+// the message is for us at all. Messages addressing the bot that it does
+// not understand return an error
+func (p *Parser) Trigger(msg Message) (string, bool, error) {
+	text, _, ok, err := p.trigger(msg)
+	return text, ok, err
+}
+
+// trigger is Trigger, also returning the byte offset of the text after the
+// trigger in the message
+func (p *Parser) trigger(msg Message) (string, int, bool, error) {
+	text := strings.TrimSpace(msg.Text)
+	if len(text) == 0 {
+		return "", 0, false, memo.ErrEmpty
+	}
+	lead := strings.Index(msg.Text, text)
 
-		// we're in a channel. don't spam in it. the message was probably not meant for us.
-		log.Tracef("Received message `%q`, not for us. ignoring", message)
-		return false, nil
+	rest, pos, ok, err := p.triggers.match(text, msg.Mentions, msg.Channels...)
+	if perr, isParseError := err.(*ParseError); isParseError {
+		perr.shift(lead)
 	}
+	return rest, lead + pos, ok, err
+}
+
+// SetTriggers sets the triggers deciding which messages are memos
+func (p *Parser) SetTriggers(triggers *Triggers) {
+	p.triggers = triggers
+}
 
-	return true, nil
+// SetTagPolicy sets the policy the tags given by users must satisfy
+func (p *Parser) SetTagPolicy(tags *memo.TagPolicy) {
+	p.tags = tags
 }
 
 // SetClock allows injection of a benbjohnson/clock clock.Clock
@@ -96,31 +292,85 @@ func (p *Parser) SetClock(clock clock.Clock) {
 }
 
 // extractTimestamp takes a timestamp at the start of the memo
-// (after memo phrase itself) written in RFC3339 format or time
-// strings compatible with [https://pkg.go.dev/github.com/raintank/dur#ParseDuration]
-// We make use of benbjohnson/clock to enable mocking of time for tests
-func (p *Parser) extractTimestamp(words []string) ([]string, time.Time) {
+// (after memo phrase itself) written as a future time (see futureTime), an
+// absolute time (see absoluteTime), in RFC3339 format or time strings
+// compatible with [https://pkg.go.dev/github.com/raintank/dur#ParseDuration]
+// Durations are subtracted from reference, clock times are in loc, and
+// without a timespec the time is offset before reference.
+// It returns the remaining words, the time and the end of a region
+func (p *Parser) extractTimestamp(words []string, reference time.Time, loc *time.Location, offset time.Duration) ([]string, time.Time, time.Time, error) {
+	if len(words) == 0 {
+		return words, reference.Add(-offset), time.Time{}, nil
+	}
+
+	ts, end, n, err := futureTime(words, reference)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	if n > 0 {
+		return words[n:], ts, end, nil
+	}
+
+	ts, n, err = absoluteTime(words, reference, loc)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	if n > 0 {
+		return words[n:], ts, time.Time{}, checkTime(ts, reference, words[:n])
+	}
+
+	if strings.HasPrefix(words[0], "-") {
+		if _, ok := offsetDuration(words[0][1:]); ok {
+			return nil, time.Time{}, time.Time{}, parseError(BadTimespec, words[0], "durations are how long ago the event took place, without a minus. use \"in 10m\" for future memos").withFix(words[0][1:])
+		}
+	}
+
 	// parse time offset out of message (if applicable) and set timestamp
-	ts := p.clock.Now().Add(-25 * time.Second)
+	ts = reference.Add(-offset)
 	dur, err := dur.ParseDuration(words[0])
 	if err == nil {
-		ts = p.clock.Now().Add(-time.Duration(dur) * time.Second)
+		ts = reference.Add(-time.Duration(dur) * time.Second)
+		err = checkTime(ts, reference, words[:1])
 		words = words[1:]
 	} else {
-		parsed, err := time.Parse(time.RFC3339, words[0])
-		if err == nil {
+		parsed, perr := time.Parse(time.RFC3339, words[0])
+		err = nil
+		if perr == nil {
 			ts = parsed
+			err = checkTime(ts, reference, words[:1])
 			words = words[1:]
 		}
 	}
 
-	return words, ts
+	return words, ts, time.Time{}, err
 }
 
+// checkTime returns an error if ts, written as spec, is after reference or
+// too long before it. Future memos are written as such, see futureTime
+func checkTime(ts, reference time.Time, spec []string) error {
+	token := strings.Join(spec, " ")
+	switch {
+	case ts.After(reference.Add(futureTolerance)):
+		return parseError(TimeInFuture, token, "%s is in the future, use \"in\" for future memos", ts.UTC().Format(time.RFC3339)).withFix("in " + shortDuration(ts.Sub(reference)))
+	case ts.Before(reference.Add(-maxAge)):
+		return parseError(TimeTooOld, token, "%s is more than a year ago", ts.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// futureTolerance is how far after the message a time may be, for clocks
+// that are a bit off
+const futureTolerance = time.Minute
+
+// maxAge is how long before the message a time may be
+const maxAge = 366 * 24 * time.Hour
+
 // New returns a new instance of Parser
 func New() Parser {
 	return Parser{
-		re:    regexp.MustCompile("^memo (.*)"),
-		clock: clock.New(),
+		triggers: DefaultTriggers(),
+		tags:     memo.DefaultTagPolicy(),
+		loc:      time.UTC,
+		clock:    clock.New(),
 	}
 }
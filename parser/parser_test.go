@@ -8,6 +8,7 @@ import (
 
 	"github.com/benbjohnson/clock"
 	"github.com/grafana/memo"
+	"github.com/grafana/memo/cfg"
 )
 
 func TestParse(t *testing.T) {
@@ -82,13 +83,48 @@ func TestParse(t *testing.T) {
 			expDesc: "some message",
 			expTags: []string{"memo", "some:tag"},
 		},
+		// timespec without message
+		{
+			msg:    "memo 5m",
+			expErr: memo.ErrEmpty,
+		},
+		// duplicate tags are removed
+		{
+			msg:     "memo some message some:tag some:tag",
+			expDate: time.Unix(10*60*60-25, 0),
+			expDesc: "some message",
+			expTags: []string{"memo", "some:tag"},
+		},
+		// chat markup at the end is text, not a tag
+		{
+			msg:     "memo see <https://example.com|runbook> some:tag",
+			expDate: time.Unix(10*60*60-25, 0),
+			expDesc: "see <https://example.com|runbook>",
+			expTags: []string{"memo", "some:tag"},
+		},
+		{
+			msg:     "memo deployed <:party:123>",
+			expDate: time.Unix(10*60*60-25, 0),
+			expDesc: "deployed <:party:123>",
+			expTags: []string{"memo"},
+		},
 		// full date-time spec and extra tag
 		{
-			msg:     "memo 1970-01-01T12:34:56Z some message some:tag xyz:tag",
-			expDate: time.Unix(12*3600+34*60+56, 0).UTC(),
+			msg:     "memo 1970-01-01T08:34:56Z some message some:tag xyz:tag",
+			expDate: time.Unix(8*3600+34*60+56, 0).UTC(),
 			expDesc: "some message",
 			expTags: []string{"memo", "some:tag", "xyz:tag"},
 		},
+		// times after the message are written as future memos
+		{
+			msg:    "memo 1970-01-01T12:34:56Z some message",
+			expErr: &ParseError{Kind: TimeInFuture},
+		},
+		// addressing the bot without a memo
+		{
+			msg:    "memo: some message",
+			expErr: &ParseError{Kind: UnknownCommand},
+		},
 	}
 
 	parser := New()
@@ -114,8 +150,365 @@ func TestParse(t *testing.T) {
 
 		m.Date = m.Date.Round(time.Second)
 
-		if m.Date != c.expDate || m.Desc != c.expDesc || !reflect.DeepEqual(c.expTags, m.Tags) {
-			t.Errorf("case %d: bad output\ninput: %#v\nexp date=%s, desc=%q, tags=%v\ngot date=%s, desc=%q, tags=%v\n", i, c.msg, c.expDate, c.expDesc, c.expTags, m.Date, m.Desc, m.Tags)
+		if m.Date != c.expDate || m.Desc != c.expDesc || !reflect.DeepEqual(c.expTags, m.Tags.Strings()) {
+			t.Errorf("case %d: bad output\ninput: %#v\nexp date=%s, desc=%q, tags=%v\ngot date=%s, desc=%q, tags=%v\n", i, c.msg, c.expDate, c.expDesc, c.expTags, m.Date, m.Desc, m.Tags.Strings())
+		}
+	}
+}
+
+func TestParseAbsolute(t *testing.T) {
+	parser := New()
+
+	// the message was written on 2024-05-02 at 12:00 UTC
+	written := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		msg     string
+		expErr  bool
+		expDate time.Time
+		expDesc string
+	}{
+		{msg: "memo at 11:05 some message", expDate: time.Date(2024, 5, 2, 11, 5, 0, 0, time.UTC), expDesc: "some message"},
+		{msg: "memo 11:05:30 some message", expDate: time.Date(2024, 5, 2, 11, 5, 30, 0, time.UTC), expDesc: "some message"},
+		// a clock time later than the message is on the day before
+		{msg: "memo 14:05 some message", expDate: time.Date(2024, 5, 1, 14, 5, 0, 0, time.UTC), expDesc: "some message"},
+		{msg: "memo today 11:05 some message", expDate: time.Date(2024, 5, 2, 11, 5, 0, 0, time.UTC), expDesc: "some message"},
+		{msg: "memo today 14:05 some message", expErr: true},
+		{msg: "memo yesterday 16:30 some message", expDate: time.Date(2024, 5, 1, 16, 30, 0, 0, time.UTC), expDesc: "some message"},
+		{msg: "memo 2024-04-01 09:00 some message", expDate: time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC), expDesc: "some message"},
+		{msg: "memo @1714550400 some message", expDate: time.Unix(1714550400, 0), expDesc: "some message"},
+		{msg: "memo @1714550400123 some message", expDate: time.Unix(1714550400, 123e6), expDesc: "some message"},
+		{msg: "memo 11:05 CET some message", expDate: time.Date(2024, 5, 2, 10, 5, 0, 0, time.UTC), expDesc: "some message"},
+		{msg: "memo 11:05 UTC+2 some message", expDate: time.Date(2024, 5, 2, 9, 5, 0, 0, time.UTC), expDesc: "some message"},
+		{msg: "memo 11:05 Europe/Amsterdam some message", expDate: time.Date(2024, 5, 2, 9, 5, 0, 0, time.UTC), expDesc: "some message"},
+		// words that are not a time stay in the text
+		{msg: "memo at the db some message", expDate: written.Add(-25 * time.Second), expDesc: "at the db some message"},
+		{msg: "memo yesterday's deploy broke", expDate: written.Add(-25 * time.Second), expDesc: "yesterday's deploy broke"},
+		{msg: "memo 11:05 DB restarted", expDate: time.Date(2024, 5, 2, 11, 5, 0, 0, time.UTC), expDesc: "DB restarted"},
+		{msg: "memo 11:05 CI/CD pipeline fixed", expDate: time.Date(2024, 5, 2, 11, 5, 0, 0, time.UTC), expDesc: "CI/CD pipeline fixed"},
+		// ambiguous or invalid input
+		{msg: "memo 2024-04-01 some message", expErr: true},
+		{msg: "memo 2024-02-30 09:00 some message", expErr: true},
+		{msg: "memo 25:00 some message", expErr: true},
+		{msg: "memo 11:05 CST some message", expErr: true},
+		{msg: "memo 11:05 Europe/Nowhere some message", expErr: true},
+		{msg: "memo @171455040012 some message", expErr: true},
+		// skipped and repeated by daylight saving changes
+		{msg: "memo 2024-03-31 02:30 Europe/Amsterdam some message", expErr: true},
+		{msg: "memo 2024-10-27 02:30 Europe/Amsterdam some message", expErr: true},
+	}
+
+	for i, c := range cases {
+		m, err := parser.ParseAt(c.msg, written)
+		if c.expErr {
+			if err == nil {
+				t.Errorf("case %d: %q: expected an error, got date=%s desc=%q", i, c.msg, m.Date, m.Desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: %q: unexpected error %s", i, c.msg, err)
+			continue
+		}
+		if !m.Date.Equal(c.expDate) || m.Desc != c.expDesc {
+			t.Errorf("case %d: %q: exp date=%s desc=%q, got date=%s desc=%q", i, c.msg, c.expDate, c.expDesc, m.Date, m.Desc)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	parser := New()
+
+	written := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		msg           string
+		expKind       ErrorKind
+		expToken      string
+		expPos        int
+		expSuggestion string
+	}{
+		{"memo: restarted db", UnknownCommand, "memo:", 0, "memo restarted db"},
+		{"memobot:", UnknownCommand, "memobot:", 0, "memo 10m <what happened>"},
+		{"memo -10m restarted db", BadTimespec, "-10m", 5, "memo 10m restarted db"},
+		{"memo 2024-05-01 restarted db", BadTimespec, "2024-05-01", 5, "memo 2024-05-01 09:00 restarted db"},
+		{"memo 11:05 CST restarted db", BadTimespec, "CST", 11, "memo 11:05 America/Chicago restarted db"},
+		{"memo in 4h..2h maintenance", BadTimespec, "4h..2h", 8, "memo in 2h..4h maintenance"},
+		{"memo 25:00 restarted db", BadTimespec, "25:00", 5, ""},
+		{"memo restarted db author:bob", InvalidTag, "author:bob", 18, "memo restarted db"},
+		{"memo today 14:05 restarted db", TimeInFuture, "today 14:05", 5, "memo in 2h5m restarted db"},
+		{"memo 2020-01-01T00:00:00Z restarted db", TimeTooOld, "2020-01-01T00:00:00Z", 5, ""},
+	}
+
+	for i, c := range cases {
+		_, err := parser.ParseAt(c.msg, written)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("case %d: %q: expected a *ParseError, got %v", i, c.msg, err)
+			continue
+		}
+		if perr.Kind != c.expKind || perr.Token != c.expToken || perr.Pos != c.expPos || perr.Suggestion != c.expSuggestion {
+			t.Errorf("case %d: %q: exp kind=%s token=%q pos=%d suggestion=%q, got kind=%s token=%q pos=%d suggestion=%q", i, c.msg, c.expKind, c.expToken, c.expPos, c.expSuggestion, perr.Kind, perr.Token, perr.Pos, perr.Suggestion)
+		}
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	written := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	newParser := func(tags cfg.Tags, triggers cfg.Triggers) Parser {
+		p := New()
+		policy, err := memo.NewTagPolicy(tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetTagPolicy(policy)
+		trig, err := NewTriggers(triggers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetTriggers(trig)
+		return p
+	}
+
+	cases := []struct {
+		parser        Parser
+		msg           string
+		expToken      string
+		expPos        int
+		expSuggestion string
+	}{
+		// the tag, not the same text in the memo
+		{newParser(cfg.Tags{}, cfg.Triggers{}), "memo author:bob wrote author:bob", "author:bob", 22, "memo author:bob wrote"},
+		// normalized tags are found as typed
+		{newParser(cfg.Tags{Normalize: true}, cfg.Triggers{}), "memo restarted db Author:Bob", "Author:Bob", 18, "memo restarted db"},
+		// quoted tags are found as typed
+		{newParser(cfg.Tags{Syntax: "rich"}, cfg.Triggers{}), `memo restarted db author:"bob smith" today`, `author:"bob smith"`, 18, "memo restarted db today"},
+		// positions are in the message, not the line of the memo
+		{newParser(cfg.Tags{}, cfg.Triggers{Multiple: true}), "memo 20m db down\nmemo -10m db up", "-10m", 22, "memo 20m db down\nmemo 10m db up"},
+	}
+
+	for i, c := range cases {
+		_, err := c.parser.ParseAll(Message{Text: c.msg, Time: written})
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("case %d: %q: expected a *ParseError, got %v", i, c.msg, err)
+			continue
 		}
+		if perr.Token != c.expToken || perr.Pos != c.expPos || perr.Suggestion != c.expSuggestion {
+			t.Errorf("case %d: %q: exp token=%q pos=%d suggestion=%q, got token=%q pos=%d suggestion=%q", i, c.msg, c.expToken, c.expPos, c.expSuggestion, perr.Token, perr.Pos, perr.Suggestion)
+		}
+	}
+}
+
+func TestParseMultiline(t *testing.T) {
+	parser := New()
+
+	m, err := parser.Parse("memo 5m restarted db env:prod\n\n* stopped writes\n  ```\n  systemctl restart db\n  ```\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expBody := "* stopped writes\n  ```\n  systemctl restart db\n  ```"
+	if m.Desc != "restarted db" || m.Body != expBody || !reflect.DeepEqual(m.Tags.Strings(), []string{"env:prod", "memo"}) {
+		t.Errorf("exp desc=%q body=%q, got desc=%q body=%q tags=%v", "restarted db", expBody, m.Desc, m.Body, m.Tags.Strings())
+	}
+
+	// without multiple memos, lines starting with memo are part of the body
+	memos, err := parser.ParseAll(Message{Text: "memo 20m db down\nmemo 10m db up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memos) != 1 || memos[0].Body != "memo 10m db up" {
+		t.Errorf("exp one memo, got %d", len(memos))
+	}
+
+	triggers, err := NewTriggers(cfg.Triggers{Multiple: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.SetTriggers(triggers)
+
+	written := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	memos, err = parser.ParseAll(Message{Text: "timeline:\nmemo 20m db down\nwrites failed\nmemo 10m db up", Time: written})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memos) != 2 {
+		t.Fatalf("exp 2 memos, got %d", len(memos))
+	}
+	if memos[0].Desc != "db down" || memos[0].Body != "writes failed" || !memos[0].Date.Equal(written.Add(-20*time.Minute)) {
+		t.Errorf("bad first memo: %+v", memos[0])
+	}
+	if memos[1].Desc != "db up" || memos[1].Body != "" || !memos[1].Date.Equal(written.Add(-10*time.Minute)) {
+		t.Errorf("bad second memo: %+v", memos[1])
+	}
+
+	// one bad memo fails them all
+	_, err = parser.ParseAll(Message{Text: "memo 20m db down\nmemo 25:00 db up", Time: written})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+
+	// messages without memos are not for us
+	memos, err = parser.ParseAll(Message{Text: "just chatting\nabout memos"})
+	if memos != nil || err != nil {
+		t.Errorf("exp no memos, got %v, %v", memos, err)
+	}
+}
+
+func TestParseRichTags(t *testing.T) {
+	tags, err := memo.NewTagPolicy(cfg.Tags{Syntax: "rich"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := New()
+	parser.SetTagPolicy(tags)
+
+	cases := []struct {
+		msg     string
+		expErr  bool
+		expDesc string
+		expTags []string
+	}{
+		{msg: "memo restarted api: took 2 min", expDesc: "restarted api: took 2 min", expTags: []string{"memo"}},
+		{msg: "memo #deploy api v2 rolled out", expDesc: "#deploy api v2 rolled out", expTags: []string{"deploy", "memo"}},
+		{msg: "memo rolled out the #api change.", expDesc: "rolled out the #api change.", expTags: []string{"api", "memo"}},
+		{msg: "memo deployed env:prod to the cluster", expDesc: "deployed to the cluster", expTags: []string{"env:prod", "memo"}},
+		{msg: `memo db failover team:"data platform"`, expDesc: "db failover", expTags: []string{"memo", "team:data platform"}},
+		{msg: "memo see https://example.com/runbook", expDesc: "see https://example.com/runbook", expTags: []string{"memo"}},
+		{msg: `memo set ratio\:3 on the lb`, expDesc: "set ratio:3 on the lb", expTags: []string{"memo"}},
+		{msg: "memo maintenance at 14:05 done", expDesc: "maintenance at 14:05 done", expTags: []string{"memo"}},
+		{msg: `memo db failover team:"data platform`, expErr: true},
+	}
+
+	for i, c := range cases {
+		m, err := parser.Parse(c.msg)
+		if c.expErr {
+			if err == nil {
+				t.Errorf("case %d: %q: expected an error, got desc=%q", i, c.msg, m.Desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: %q: unexpected error %s", i, c.msg, err)
+			continue
+		}
+		if m.Desc != c.expDesc || !reflect.DeepEqual(m.Tags.Strings(), c.expTags) {
+			t.Errorf("case %d: %q: exp desc=%q tags=%v, got desc=%q tags=%v", i, c.msg, c.expDesc, c.expTags, m.Desc, m.Tags.Strings())
+		}
+	}
+}
+
+func TestParseFuture(t *testing.T) {
+	parser := New()
+
+	written := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		msg     string
+		expErr  bool
+		expDate time.Time
+		expEnd  time.Time
+		expDesc string
+	}{
+		{msg: "memo +30m freeze starts", expDate: written.Add(30 * time.Minute), expDesc: "freeze starts"},
+		{msg: "memo in 2h db maintenance", expDate: written.Add(2 * time.Hour), expDesc: "db maintenance"},
+		{msg: "memo in 2h..4h db maintenance", expDate: written.Add(2 * time.Hour), expEnd: written.Add(4 * time.Hour), expDesc: "db maintenance"},
+		{msg: "memo +30m..1h30m db maintenance", expDate: written.Add(30 * time.Minute), expEnd: written.Add(90 * time.Minute), expDesc: "db maintenance"},
+		// words that are not a future time stay in the text
+		{msg: "memo in the meantime the db restarted", expDate: written.Add(-25 * time.Second), expDesc: "in the meantime the db restarted"},
+		{msg: "memo +1 to that", expDate: written.Add(-25 * time.Second), expDesc: "+1 to that"},
+		// the end must be after the start
+		{msg: "memo in 4h..2h db maintenance", expErr: true},
+		{msg: "memo in 2h.. db maintenance", expErr: true},
+	}
+
+	for i, c := range cases {
+		m, err := parser.ParseAt(c.msg, written)
+		if c.expErr {
+			if err == nil {
+				t.Errorf("case %d: %q: expected an error, got date=%s desc=%q", i, c.msg, m.Date, m.Desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: %q: unexpected error %s", i, c.msg, err)
+			continue
+		}
+		if !m.Date.Equal(c.expDate) || !m.End.Equal(c.expEnd) || m.Desc != c.expDesc {
+			t.Errorf("case %d: %q: exp date=%s end=%s desc=%q, got date=%s end=%s desc=%q", i, c.msg, c.expDate, c.expEnd, c.expDesc, m.Date, m.End, m.Desc)
+		}
+	}
+}
+
+func TestParseIn(t *testing.T) {
+	parser := New()
+
+	written := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		msg     string
+		expDate time.Time
+	}{
+		// clock times are in the zone of the user
+		{"memo 11:05 some message", time.Date(2024, 5, 2, 9, 5, 0, 0, time.UTC)},
+		{"memo yesterday 16:30 some message", time.Date(2024, 5, 1, 14, 30, 0, 0, time.UTC)},
+		// unless the message gives one
+		{"memo 11:05 UTC some message", time.Date(2024, 5, 2, 11, 5, 0, 0, time.UTC)},
+		// durations do not depend on the zone
+		{"memo 5m some message", written.Add(-5 * time.Minute)},
+	}
+
+	for i, c := range cases {
+		m, err := parser.ParseIn(c.msg, written, amsterdam)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %s", i, err)
+		}
+		if !m.Date.Equal(c.expDate) {
+			t.Errorf("case %d: %q: exp date %s, got %s", i, c.msg, c.expDate, m.Date)
+		}
+	}
+}
+
+func TestParseAt(t *testing.T) {
+	mock := clock.NewMock()
+	mock.Add(10 * time.Hour)
+
+	parser := New()
+	parser.SetClock(mock)
+
+	// the message was written an hour before it is processed
+	written := time.Unix(9*60*60, 0)
+
+	cases := []struct {
+		msg     string
+		expDate time.Time
+	}{
+		{"memo some message", written.Add(-25 * time.Second)},
+		{"memo 5m some message", written.Add(-5 * time.Minute)},
+		{"memo 1970-01-01T08:34:56Z some message", time.Unix(8*3600+34*60+56, 0)},
+	}
+
+	for i, c := range cases {
+		m, err := parser.ParseAt(c.msg, written)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %s", i, err)
+		}
+		if !m.Date.Equal(c.expDate) {
+			t.Errorf("case %d: exp date %s, got %s", i, c.expDate, m.Date)
+		}
+	}
+
+	// without a reference, times are relative to now
+	m, err := parser.ParseAt("memo 5m some message", time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if exp := mock.Now().Add(-5 * time.Minute); !m.Date.Equal(exp) {
+		t.Errorf("exp date %s, got %s", exp, m.Date)
 	}
 }
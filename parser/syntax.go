@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// tokenRe splits a memo into words, keeping key:"quoted values" whole
+	tokenRe = regexp.MustCompile(`\S*?:"[^"]*"|\S+`)
+	// quotedTagRe matches key:"quoted value"
+	quotedTagRe = regexp.MustCompile(`^([A-Za-z][\w.-]*):"([^"]*)"$`)
+	// tagRe matches key:value
+	tagRe = regexp.MustCompile(`^([A-Za-z][\w.-]*):(\S+)$`)
+	// hashtagRe matches #hashtag, with trailing punctuation
+	hashtagRe = regexp.MustCompile(`^#([A-Za-z][\w-]*)[.,;:!?)]*$`)
+	// urlRe matches words starting with a URL scheme, like https://
+	urlRe = regexp.MustCompile(`^<?[A-Za-z][A-Za-z0-9+.-]*://`)
+)
+
+// typedTag is a tag with how and where the user typed it
+type typedTag struct {
+	// tag as given to the tag policy
+	tag string
+	// token is the tag as typed
+	token string
+	// pos is the byte offset of token in the message
+	pos int
+}
+
+// tagNames returns the tags of typed
+func tagNames(typed []typedTag) []string {
+	var tags []string
+	for _, t := range typed {
+		tags = append(tags, t.tag)
+	}
+	return tags
+}
+
+// classicTags splits the words of a memo, at the byte offsets in the
+// message, into its text and the tags at its end, which are the words with
+// a colon. Chat markup such as <https://example.com|runbook> is text
+func classicTags(words []string, offsets []int) (string, []typedTag) {
+	pos := len(words) - 1 // pos of the last word that is not a tag
+	for strings.Contains(words[pos], ":") && !strings.HasPrefix(words[pos], "<") {
+		pos--
+		if pos < 0 {
+			return strings.Join(words, " "), nil
+		}
+	}
+
+	var tags []typedTag
+	for i := pos + 1; i < len(words); i++ {
+		tags = append(tags, typedTag{tag: words[i], token: words[i], pos: offsets[i]})
+	}
+	return strings.Join(words[:pos+1], " "), tags
+}
+
+// richTags splits the text of a memo, at byte offset base in the message,
+// into its text and its tags, which are key:value and key:"quoted value"
+// anywhere, and #hashtags, which also stay in the text. URLs stay in the
+// text, and words with an escaped colon (\:) stay in the text with a plain
+// colon
+func richTags(text string, base int) (string, []typedTag, error) {
+	var words []string
+	var tags []typedTag
+	for _, loc := range tokenRe.FindAllStringIndex(text, -1) {
+		word := text[loc[0]:loc[1]]
+		pos := base + loc[0]
+		switch {
+		case strings.Contains(word, `\:`):
+			words = append(words, strings.Replace(word, `\:`, ":", -1))
+		case urlRe.MatchString(word):
+			words = append(words, word)
+		case quotedTagRe.MatchString(word):
+			m := quotedTagRe.FindStringSubmatch(word)
+			tags = append(tags, typedTag{tag: m[1] + ":" + m[2], token: word, pos: pos})
+		case tagRe.MatchString(word):
+			m := tagRe.FindStringSubmatch(word)
+			if strings.HasPrefix(m[2], `"`) {
+				return "", nil, parseError(InvalidTag, word, "tag %q has no closing quote", word).withFix(word + `"`).at(pos)
+			}
+			tags = append(tags, typedTag{tag: word, token: word, pos: pos})
+		case hashtagRe.MatchString(word):
+			tags = append(tags, typedTag{tag: hashtagRe.FindStringSubmatch(word)[1], token: word, pos: pos})
+			words = append(words, word)
+		default:
+			words = append(words, word)
+		}
+	}
+
+	// a memo of only tags is all text, like in the classic syntax
+	if len(words) == 0 {
+		return strings.Join(strings.Fields(text), " "), nil, nil
+	}
+
+	return strings.Join(words, " "), tags, nil
+}
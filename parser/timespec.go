@@ -0,0 +1,314 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/raintank/dur"
+)
+
+var (
+	// epochRe matches "@1714550400", seconds or milliseconds since the epoch
+	epochRe = regexp.MustCompile(`^@(\d+)$`)
+	// dateRe matches "2024-05-01"
+	dateRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	// clockRe matches "14:05" and "14:05:30"
+	clockRe = regexp.MustCompile(`^(\d{1,2}):(\d{2})(?::(\d{2}))?$`)
+	// offsetRe matches "UTC+2", "GMT-05:30" and "+02:00"
+	offsetRe = regexp.MustCompile(`^(?:(?:UTC|GMT)([+-])(\d{1,2})(?::?(\d{2}))?|([+-])(\d{2}):?(\d{2}))$`)
+	// ianaRe matches zone names such as "Europe/Amsterdam". only those in
+	// zoneAreas are certainly meant as a zone
+	ianaRe = regexp.MustCompile(`^[A-Z][A-Za-z_]+(?:/[A-Za-z_+-]+)+$`)
+)
+
+// zoneAbbreviations are the zone abbreviations we accept, with their fixed
+// offset in hours. "CET" is always +01:00, also in summer
+var zoneAbbreviations = map[string]int{
+	"UTC":  0,
+	"GMT":  0,
+	"WET":  0,
+	"WEST": 1,
+	"CET":  1,
+	"CEST": 2,
+	"EET":  2,
+	"EEST": 3,
+	"EST":  -5,
+	"EDT":  -4,
+	"CDT":  -5,
+	"MST":  -7,
+	"MDT":  -6,
+	"PST":  -8,
+	"PDT":  -7,
+	"JST":  9,
+	"AEST": 10,
+	"AEDT": 11,
+}
+
+// ambiguousZones are abbreviations used for several zones, with the zone
+// names to use instead
+var ambiguousZones = map[string][]string{
+	"CST": {"America/Chicago", "Asia/Shanghai"},
+	"IST": {"Asia/Kolkata", "Europe/Dublin"},
+	"BST": {"Europe/London", "Asia/Dhaka"},
+}
+
+// zoneAreas are the areas of the IANA zone names. an unknown zone in one of
+// them is an error, other words with a slash are text
+var zoneAreas = map[string]bool{
+	"Africa": true, "America": true, "Antarctica": true, "Arctic": true,
+	"Asia": true, "Atlantic": true, "Australia": true, "Europe": true,
+	"Indian": true, "Pacific": true, "Etc": true,
+}
+
+// absoluteTime parses an absolute or natural-language time at the start of
+// words, relative to reference. It returns the time and the number of words
+// it used, which is 0 if words do not start with such a time. Accepted are
+//
+//	@1714550400          epoch seconds, or milliseconds with 13 digits
+//	14:05, at 14:05      the latest 14:05 not after reference
+//	today 14:05          14:05 on the day of reference
+//	yesterday 16:30      16:30 on the day before reference
+//	2024-05-01 09:00     09:00 on the given day
+//
+// Clock times may be followed by a zone such as "CET", "UTC+2" or
+// "Europe/Amsterdam", and otherwise are in loc
+func absoluteTime(words []string, reference time.Time, loc *time.Location) (time.Time, int, error) {
+	if m := epochRe.FindStringSubmatch(words[0]); m != nil {
+		ts, err := epoch(m[1])
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		return ts, 1, nil
+	}
+
+	i := 0
+	days := 0
+	rollback := false
+	var date time.Time
+	switch {
+	case dateRe.MatchString(words[0]):
+		d, err := time.Parse("2006-01-02", words[0])
+		if err != nil {
+			return time.Time{}, 0, parseError(BadTimespec, words[0], "invalid date %q", words[0])
+		}
+		date = d
+		i = 1
+	case strings.EqualFold(words[0], "yesterday"):
+		days = -1
+		i = 1
+	case strings.EqualFold(words[0], "today"):
+		i = 1
+	case strings.EqualFold(words[0], "at"):
+		rollback = true
+		i = 1
+	default:
+		rollback = true
+	}
+
+	if i >= len(words) || !clockRe.MatchString(words[i]) {
+		if !date.IsZero() {
+			return time.Time{}, 0, parseError(BadTimespec, words[0], "date %q needs a time of day", words[0]).withFix(words[0] + " 09:00")
+		}
+		return time.Time{}, 0, nil
+	}
+	h, min, sec, err := timeOfDay(words[i])
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	i++
+
+	if i < len(words) {
+		z, ok, err := zone(words[i])
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		if ok {
+			loc = z
+			i++
+		}
+	}
+
+	y, mon, d := reference.In(loc).Date()
+	if !date.IsZero() {
+		y, mon, d = date.Date()
+	}
+	d += days
+
+	ts, err := wallClock(y, mon, d, h, min, sec, loc)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if rollback && ts.After(reference) {
+		ts, err = wallClock(y, mon, d-1, h, min, sec, loc)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+	}
+
+	return ts, i, nil
+}
+
+// futureTime parses a time after reference at the start of words. It
+// returns the start, the end if it is a region, and the number of words it
+// used, which is 0 if words do not start with such a time. Accepted are
+//
+//	+30m, in 30m         30 minutes after reference
+//	+2h..4h, in 2h..4h   the region from 2 to 4 hours after reference
+//
+// Durations need a unit, so "+1" stays in the text
+func futureTime(words []string, reference time.Time) (time.Time, time.Time, int, error) {
+	spec := words[0]
+	n := 1
+	switch {
+	case strings.HasPrefix(spec, "+"):
+		spec = spec[1:]
+	case strings.EqualFold(spec, "in") && len(words) > 1:
+		spec = words[1]
+		n = 2
+	default:
+		return time.Time{}, time.Time{}, 0, nil
+	}
+
+	parts := strings.SplitN(spec, "..", 2)
+	start, ok := offsetDuration(parts[0])
+	if !ok {
+		if len(parts) == 2 {
+			return time.Time{}, time.Time{}, 0, parseError(BadTimespec, spec, "invalid range %q, use durations such as \"2h..4h\"", spec)
+		}
+		return time.Time{}, time.Time{}, 0, nil
+	}
+	if len(parts) == 1 {
+		return reference.Add(start), time.Time{}, n, nil
+	}
+
+	end, ok := offsetDuration(strings.TrimPrefix(parts[1], "+"))
+	if !ok || end <= start {
+		err := parseError(BadTimespec, spec, "invalid range %q, the end must be a duration after the start, such as \"2h..4h\"", spec)
+		if ok && end < start {
+			err = err.withFix(strings.TrimPrefix(parts[1], "+") + ".." + parts[0])
+		}
+		return time.Time{}, time.Time{}, 0, err
+	}
+	return reference.Add(start), reference.Add(end), n, nil
+}
+
+// offsetDuration parses a duration with a unit, such as "30m" or "1h30m"
+func offsetDuration(spec string) (time.Duration, bool) {
+	if spec == "" || !unicode.IsLetter(rune(spec[len(spec)-1])) {
+		return 0, false
+	}
+	d, err := dur.ParseDuration(spec)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(d) * time.Second, true
+}
+
+// shortDuration formats d in hours and minutes, such as "2h5m", rounded up
+// to the minute
+func shortDuration(d time.Duration) string {
+	mins := int((d + time.Minute - 1) / time.Minute)
+	switch {
+	case mins < 60:
+		return fmt.Sprintf("%dm", mins)
+	case mins%60 == 0:
+		return fmt.Sprintf("%dh", mins/60)
+	default:
+		return fmt.Sprintf("%dh%dm", mins/60, mins%60)
+	}
+}
+
+// epoch parses seconds, or milliseconds if it has 13 digits, since the epoch
+func epoch(digits string) (time.Time, error) {
+	if len(digits) != 13 && len(digits) > 10 {
+		return time.Time{}, parseError(BadTimespec, "@"+digits, "ambiguous epoch time %q, use seconds (10 digits) or milliseconds (13 digits)", "@"+digits)
+	}
+
+	n, _ := strconv.ParseInt(digits, 10, 64)
+	if len(digits) == 13 {
+		return time.Unix(0, n*int64(time.Millisecond)), nil
+	}
+	return time.Unix(n, 0), nil
+}
+
+// timeOfDay parses "14:05" or "14:05:30" into hours, minutes and seconds
+func timeOfDay(word string) (int, int, int, error) {
+	m := clockRe.FindStringSubmatch(word)
+	h, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	sec := 0
+	if m[3] != "" {
+		sec, _ = strconv.Atoi(m[3])
+	}
+	if h > 23 || min > 59 || sec > 59 {
+		return 0, 0, 0, parseError(BadTimespec, word, "invalid time of day %q, use 24-hour time such as \"14:05\"", word)
+	}
+	return h, min, sec, nil
+}
+
+// zone returns the location named by word, and whether word is a zone at
+// all. Abbreviations used for several zones are an error
+func zone(word string) (*time.Location, bool, error) {
+	if offset, ok := zoneAbbreviations[word]; ok {
+		return time.FixedZone(word, offset*60*60), true, nil
+	}
+	if zones, ok := ambiguousZones[word]; ok {
+		return nil, false, parseError(BadTimespec, word, "time zone %q is ambiguous, use %s", word, strings.Join(zones, " or ")).withFix(zones[0])
+	}
+
+	if m := offsetRe.FindStringSubmatch(word); m != nil {
+		sign, hours, mins := m[1], m[2], m[3]
+		if sign == "" {
+			sign, hours, mins = m[4], m[5], m[6]
+		}
+		h, _ := strconv.Atoi(hours)
+		min := 0
+		if mins != "" {
+			min, _ = strconv.Atoi(mins)
+		}
+		if h > 14 || min > 59 {
+			return nil, false, parseError(BadTimespec, word, "invalid time zone offset %q", word)
+		}
+		offset := h*60*60 + min*60
+		if sign == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(word, offset), true, nil
+	}
+
+	if ianaRe.MatchString(word) {
+		loc, err := time.LoadLocation(word)
+		if err == nil {
+			return loc, true, nil
+		}
+		// words like "CI/CD" are not meant as a zone
+		if zoneAreas[strings.SplitN(word, "/", 2)[0]] {
+			return nil, false, parseError(BadTimespec, word, "unknown time zone %q", word)
+		}
+	}
+
+	return nil, false, nil
+}
+
+// wallClock returns the time at the given wall clock in loc. Wall clock
+// times skipped or repeated by a daylight saving change are an error
+func wallClock(y int, mon time.Month, d, h, min, sec int, loc *time.Location) (time.Time, error) {
+	ts := time.Date(y, mon, d, h, min, sec, 0, loc)
+	clock := fmt.Sprintf("%02d:%02d", h, min)
+	if ts.Hour() != h || ts.Minute() != min {
+		return time.Time{}, parseError(BadTimespec, clock, "%s does not exist in %s on %s, as the clocks change", clock, loc, ts.Format("2006-01-02"))
+	}
+
+	for _, shift := range []time.Duration{-time.Hour, time.Hour} {
+		other := ts.Add(shift).In(loc)
+		if other.Day() == ts.Day() && other.Hour() == h && other.Minute() == min {
+			return time.Time{}, parseError(BadTimespec, clock, "%s happens twice in %s on %s, as the clocks change. add an offset such as \"UTC+2\"", clock, loc, ts.Format("2006-01-02"))
+		}
+	}
+
+	return ts, nil
+}
@@ -0,0 +1,253 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/memo/cfg"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultWords are the words starting a memo when none are configured
+var DefaultWords = []string{"memo"}
+
+// DefaultOffset is how long before the message the event is assumed to have
+// happened when none is configured
+const DefaultOffset = 25 * time.Second
+
+// helpPrefixes are the names users address the bot by, besides the trigger
+// words followed by a colon. such messages get a help reply
+var helpPrefixes = []string{"mrbot:", "memobot:"}
+
+// triggerRules are the compiled rules of a cfg.Triggers, for one channel
+type triggerRules struct {
+	// words are lowercased
+	words   []string
+	regexes []*regexp.Regexp
+	offset  time.Duration
+}
+
+// Triggers decides which messages are memos. It is safe for concurrent use
+// and can be updated on config reload
+type Triggers struct {
+	mu sync.RWMutex
+
+	mention  bool
+	multiple bool
+	rules    triggerRules
+	// channels maps lowercased channel names and IDs to their rules
+	channels map[string]triggerRules
+}
+
+// NewTriggers returns the triggers for config
+func NewTriggers(config cfg.Triggers) (*Triggers, error) {
+	t := &Triggers{}
+	err := t.Update(config)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// DefaultTriggers returns the triggers used when none are configured: the
+// word "memo" and an offset of 25 seconds
+func DefaultTriggers() *Triggers {
+	t, _ := NewTriggers(cfg.Triggers{})
+	return t
+}
+
+// Update replaces the triggers with config. The triggers are left unchanged
+// if config is invalid
+func (t *Triggers) Update(config cfg.Triggers) error {
+	rules, err := compileTriggers(triggerRules{words: DefaultWords, offset: DefaultOffset}, config.Words, config.Regexes, config.DefaultOffset)
+	if err != nil {
+		return err
+	}
+
+	channels := map[string]triggerRules{}
+	for ch, c := range config.Channels {
+		r, err := compileTriggers(rules, c.Words, c.Regexes, c.DefaultOffset)
+		if err != nil {
+			return fmt.Errorf("triggers.channels.%s: %s", ch, err)
+		}
+		channels[strings.ToLower(strings.TrimPrefix(ch, "#"))] = r
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mention = config.Mention
+	t.multiple = config.Multiple
+	t.rules = rules
+	t.channels = channels
+	return nil
+}
+
+// compileTriggers returns the rules of base, overridden by the words,
+// regexes and offset that are set
+func compileTriggers(base triggerRules, words, regexes []string, offset *cfg.Duration) (triggerRules, error) {
+	r := base
+
+	if len(words) > 0 {
+		r.words = nil
+		for _, w := range words {
+			r.words = append(r.words, strings.ToLower(w))
+		}
+	}
+
+	if len(regexes) > 0 {
+		r.regexes = nil
+		for _, re := range regexes {
+			compiled, err := regexp.Compile(re)
+			if err != nil {
+				return triggerRules{}, fmt.Errorf("invalid trigger regex %q: %s", re, err)
+			}
+			r.regexes = append(r.regexes, compiled)
+		}
+	}
+
+	if offset != nil {
+		if offset.Duration < 0 {
+			return triggerRules{}, errors.New("default_offset must not be negative")
+		}
+		r.offset = offset.Duration
+	}
+
+	return r, nil
+}
+
+// forChannel returns the rules of the first of channels that has its own,
+// or the global rules
+func (t *Triggers) forChannel(channels ...string) triggerRules {
+	for _, ch := range channels {
+		if r, ok := t.channels[strings.ToLower(strings.TrimPrefix(ch, "#"))]; ok {
+			return r
+		}
+	}
+	return t.rules
+}
+
+// Match returns the text of the memo if the message is one, in the channel
+// with the given name or ID. mentions are how the bot can be @-mentioned.
+// Messages addressing the bot that are not memos return an error
+func (t *Triggers) Match(text string, mentions []string, channels ...string) (string, bool, error) {
+	rest, _, ok, err := t.match(text, mentions, channels...)
+	return rest, ok, err
+}
+
+// match is Match, also returning the byte offset of the memo text in text
+func (t *Triggers) match(text string, mentions []string, channels ...string) (string, int, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	r := t.forChannel(channels...)
+
+	first := text
+	if i := strings.IndexFunc(text, isSpace); i >= 0 {
+		first = text[:i]
+	}
+	for _, w := range r.words {
+		if strings.ToLower(first) == w {
+			rest, pos := trimFrom(text, len(first))
+			return rest, pos, true, nil
+		}
+	}
+
+	if t.mention {
+		for _, m := range mentions {
+			if m != "" && strings.HasPrefix(text, m) {
+				from := len(m)
+				if strings.HasPrefix(text[from:], ":") {
+					from++
+				}
+				rest, pos := trimFrom(text, from)
+				return rest, pos, true, nil
+			}
+		}
+	}
+
+	for _, re := range r.regexes {
+		loc := re.FindStringSubmatchIndex(text)
+		if loc == nil {
+			continue
+		}
+		// the lines after the match are the body. the rest of the line
+		// of the match is not part of the capture
+		if len(loc) > 2 && loc[2] >= 0 {
+			body := ""
+			if i := strings.Index(text[loc[1]:], "\n"); i >= 0 {
+				body = text[loc[1]+i:]
+			}
+			rest, pos := trimFrom(text[:loc[3]]+body, loc[2])
+			return rest, pos, true, nil
+		}
+		rest, pos := trimFrom(text, loc[1])
+		return rest, pos, true, nil
+	}
+
+	lower := strings.ToLower(first)
+	for _, prefix := range append(prefixes(r.words), helpPrefixes...) {
+		if strings.HasPrefix(lower, prefix) {
+			return "", 0, false, notUnderstood(text, first[:len(prefix)], r.words[0])
+		}
+	}
+
+	// we're in a channel. don't spam in it. the message was probably not meant for us.
+	log.Tracef("Received message `%q`, not for us. ignoring", text)
+	return "", 0, false, nil
+}
+
+// trimFrom returns text from the byte offset from on, without the space
+// around it, and the offset it starts at
+func trimFrom(text string, from int) (string, int) {
+	rest := strings.TrimSpace(text[from:])
+	if rest == "" {
+		return "", from
+	}
+	return rest, from + strings.Index(text[from:], rest)
+}
+
+// prefixes returns the words followed by a colon
+func prefixes(words []string) []string {
+	var out []string
+	for _, w := range words {
+		out = append(out, w+":")
+	}
+	return out
+}
+
+// notUnderstood returns the error for a message addressing the bot as
+// prefix that is not a memo, suggesting to use word instead
+func notUnderstood(text, prefix, word string) error {
+	log.Debugf("A user seems to direct a message `%q` to us, but we don't understand it. so sending help message back", text)
+	err := parseError(UnknownCommand, prefix, "message could not be understood").at(0)
+	if strings.TrimSpace(text[len(prefix):]) == "" {
+		return err.withFix(word + " 10m <what happened>")
+	}
+	return err.withFix(word + " ")
+}
+
+// Multiple returns whether each line of a message starting with a trigger
+// is its own memo
+func (t *Triggers) Multiple() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.multiple
+}
+
+// Offset returns how long before the message the event is assumed to have
+// happened in the channel with the given name or ID
+func (t *Triggers) Offset(channels ...string) time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.forChannel(channels...).offset
+}
+
+// isSpace reports whether r is white space
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
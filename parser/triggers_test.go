@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/memo/cfg"
+)
+
+func TestTriggers(t *testing.T) {
+	zero := cfg.Duration{}
+	triggers, err := NewTriggers(cfg.Triggers{
+		Words:   []string{"note", "Annotate"},
+		Mention: true,
+		Regexes: []string{`^!m\s+(.*)`, `^\[memo\]`, `^\{m\} (.*?) \{end\}`},
+		Channels: map[string]cfg.ChannelTriggers{
+			"#ops": {Words: []string{"memo"}, DefaultOffset: &zero},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mentions := []string{"<@U1>"}
+	cases := []struct {
+		text     string
+		channels []string
+		expText  string
+		expOk    bool
+		expErr   bool
+	}{
+		{text: "note restarted db", expText: "restarted db", expOk: true},
+		{text: "ANNOTATE restarted db", expText: "restarted db", expOk: true},
+		{text: "<@U1> restarted db", expText: "restarted db", expOk: true},
+		{text: "<@U1>: restarted db", expText: "restarted db", expOk: true},
+		{text: "!m restarted db", expText: "restarted db", expOk: true},
+		{text: "[memo] restarted db", expText: "restarted db", expOk: true},
+		// the rest of the line after the match is not part of the memo,
+		// the lines after it are
+		{text: "{m} restarted db {end} please ignore", expText: "restarted db", expOk: true},
+		{text: "{m} restarted db {end} please ignore\nit was slow", expText: "restarted db\nit was slow", expOk: true},
+		// the default word is replaced
+		{text: "memo restarted db"},
+		{text: "notes are useful"},
+		{text: "note: what", expErr: true},
+		{text: "memobot: help", expErr: true},
+		// channels have their own words
+		{text: "memo restarted db", channels: []string{"C1", "ops"}, expText: "restarted db", expOk: true},
+		{text: "note restarted db", channels: []string{"C1", "ops"}},
+		{text: "note restarted db", channels: []string{"C1", "dev"}, expText: "restarted db", expOk: true},
+	}
+
+	for i, c := range cases {
+		text, ok, err := triggers.Match(c.text, mentions, c.channels...)
+		if (err != nil) != c.expErr || ok != c.expOk || text != c.expText {
+			t.Errorf("case %d: %q: exp text=%q ok=%t err=%t, got text=%q ok=%t err=%v", i, c.text, c.expText, c.expOk, c.expErr, text, ok, err)
+		}
+	}
+
+	if offset := triggers.Offset("C1", "dev"); offset != DefaultOffset {
+		t.Errorf("exp default offset %s, got %s", DefaultOffset, offset)
+	}
+	if offset := triggers.Offset("C1", "ops"); offset != 0 {
+		t.Errorf("exp offset 0 in #ops, got %s", offset)
+	}
+
+	// mentions only trigger if enabled
+	triggers.Update(cfg.Triggers{})
+	if _, ok, _ := triggers.Match("<@U1> restarted db", mentions); ok {
+		t.Errorf("mention triggered a memo while disabled")
+	}
+
+	_, err = NewTriggers(cfg.Triggers{Regexes: []string{"("}})
+	if err == nil {
+		t.Errorf("expected an error for an invalid regex")
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	offset := cfg.Duration{Duration: time.Minute}
+	triggers, err := NewTriggers(cfg.Triggers{DefaultOffset: &offset})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parser := New()
+	parser.SetTriggers(triggers)
+
+	written := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	m, err := parser.ParseMessage(Message{Text: "memo restarted db", Time: written})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := written.Add(-time.Minute); !m.Date.Equal(exp) {
+		t.Errorf("exp date %s, got %s", exp, m.Date)
+	}
+}
@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/metrics"
+)
+
+// maxBuckets is the number of buckets after which idle ones are pruned
+const maxBuckets = 10000
+
+// Scopes a limit applies to
+const (
+	ScopeUser    = "user"
+	ScopeChannel = "channel"
+	ScopeSource  = "source"
+)
+
+// LimitedError is returned when a memo exceeds a rate limit
+type LimitedError struct {
+	// Scope of the limit that was hit
+	Scope string
+	// RetryAfter is when the next memo would be allowed
+	RetryAfter time.Duration
+}
+
+// Error implements error
+func (e *LimitedError) Error() string {
+	var who string
+	switch e.Scope {
+	case ScopeUser:
+		who = "from you"
+	case ScopeChannel:
+		who = "in this channel"
+	default:
+		who = "overall"
+	}
+	return fmt.Sprintf("too many memos %s, try again in %s", who, e.RetryAfter.Round(time.Second))
+}
+
+// bucket is a token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limit is a compiled cfg.Limit
+type limit struct {
+	burst float64
+	every time.Duration
+}
+
+// enabled returns whether the limit is configured
+func (l limit) enabled() bool {
+	return l.burst > 0 && l.every > 0
+}
+
+// Limiter enforces token bucket rate limits keyed by user, channel and
+// source. It is safe for concurrent use
+type Limiter struct {
+	mu      sync.Mutex
+	limits  map[string]limit
+	buckets map[string]*bucket
+
+	// for mocking times in tests
+	clock clock.Clock
+}
+
+// New returns a limiter for config
+func New(config cfg.RateLimit) *Limiter {
+	l := &Limiter{
+		buckets: map[string]*bucket{},
+		clock:   clock.New(),
+	}
+	l.Update(config)
+	return l
+}
+
+// SetClock allows injection of a benbjohnson/clock clock.Clock
+// interface, for mocking within tests.
+func (l *Limiter) SetClock(clock clock.Clock) {
+	l.clock = clock
+}
+
+// Update replaces the limits with config, keeping the state of the buckets
+func (l *Limiter) Update(config cfg.RateLimit) {
+	limits := map[string]limit{
+		ScopeUser:    {burst: float64(config.User.Burst), every: config.User.Every.Duration},
+		ScopeChannel: {burst: float64(config.Channel.Burst), every: config.Channel.Every.Duration},
+		ScopeSource:  {burst: float64(config.Source.Burst), every: config.Source.Every.Duration},
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits = limits
+}
+
+// Allow takes a token for the memo from the user, channel and source
+// buckets. If any of them is empty, no token is taken and a
+// *LimitedError is returned
+func (l *Limiter) Allow(source, channel, user string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	keys := []struct {
+		scope string
+		key   string
+	}{
+		{ScopeUser, source + "/" + user},
+		{ScopeChannel, source + "/" + channel},
+		{ScopeSource, source},
+	}
+
+	var take []*bucket
+	for _, k := range keys {
+		lim := l.limits[k.scope]
+		if !lim.enabled() {
+			continue
+		}
+
+		b := l.refill(k.scope+"/"+k.key, lim, now)
+		if b.tokens < 1 {
+			metrics.RateLimited.WithLabelValues(source, k.scope).Inc()
+			wait := time.Duration((1 - b.tokens) * float64(lim.every))
+			return &LimitedError{Scope: k.scope, RetryAfter: wait}
+		}
+		take = append(take, b)
+	}
+
+	for _, b := range take {
+		b.tokens--
+	}
+
+	if len(l.buckets) > maxBuckets {
+		l.prune(now)
+	}
+
+	return nil
+}
+
+// refill returns the bucket for key, topped up for the time passed
+func (l *Limiter) refill(key string, lim limit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: lim.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens = math.Min(lim.burst, b.tokens+float64(now.Sub(b.last))/float64(lim.every))
+	b.last = now
+	return b
+}
+
+// prune removes the buckets that have been idle long enough to be full
+func (l *Limiter) prune(now time.Time) {
+	var longest time.Duration
+	for _, lim := range l.limits {
+		if d := time.Duration(lim.burst) * lim.every; d > longest {
+			longest = d
+		}
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > longest {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/memo/cfg"
+)
+
+func TestAllow(t *testing.T) {
+	mock := clock.NewMock()
+
+	l := New(cfg.RateLimit{
+		User:    cfg.Limit{Burst: 2, Every: cfg.Duration{Duration: time.Minute}},
+		Channel: cfg.Limit{Burst: 3, Every: cfg.Duration{Duration: time.Minute}},
+	})
+	l.SetClock(mock)
+
+	expect := func(step, source, channel, user string, scope string) {
+		t.Helper()
+		err := l.Allow(source, channel, user)
+		if scope == "" {
+			if err != nil {
+				t.Errorf("%s: expected memo to be allowed, got %v", step, err)
+			}
+			return
+		}
+		lerr, ok := err.(*LimitedError)
+		if !ok || lerr.Scope != scope {
+			t.Errorf("%s: expected %s limit, got %v", step, scope, err)
+		}
+	}
+
+	expect("first of burst", "slack", "ops", "alice", "")
+	expect("second of burst", "slack", "ops", "alice", "")
+	expect("user burst exhausted", "slack", "ops", "alice", ScopeUser)
+	expect("other user", "slack", "ops", "bob", "")
+	expect("channel burst exhausted", "slack", "ops", "carol", ScopeChannel)
+	expect("other channel", "slack", "dev", "carol", "")
+	expect("other source", "discord", "ops", "alice", "")
+
+	mock.Add(time.Minute)
+	expect("refilled", "slack", "ops", "alice", "")
+	expect("refill is one token", "slack", "ops", "alice", ScopeUser)
+	expect("channel refill is one token", "slack", "ops", "bob", ScopeChannel)
+
+	l.Update(cfg.RateLimit{})
+	expect("limits removed", "slack", "ops", "alice", "")
+}
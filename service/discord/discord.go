@@ -1,14 +1,21 @@
 package discord
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/bwmarrin/discordgo"
 	mem "github.com/grafana/memo"
+	"github.com/grafana/memo/auth"
 	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/dedup"
+	"github.com/grafana/memo/metrics"
 	"github.com/grafana/memo/parser"
+	"github.com/grafana/memo/ratelimit"
 	"github.com/grafana/memo/service"
 	"github.com/grafana/memo/store"
 )
@@ -22,78 +29,382 @@ type DiscordService struct {
 	parser parser.Parser
 	// store puts the memo in the defined store
 	store store.Store
+	// policy decides who may create memos, and where
+	policy *auth.Policy
+	// limiter rate limits memos by user, channel and source
+	limiter *ratelimit.Limiter
+	// dedup suppresses duplicate memos
+	dedup *dedup.Filter
+	// tags provides the channel default tags
+	tags *mem.TagPolicy
+	// pool handles the messages, in order per channel
+	pool *service.Pool
+	// handle is called by the pool for each message, handleMessage but in
+	// tests
+	handle func(*discordgo.Session, *discordgo.MessageCreate)
+	// timezones of the users
+	timezones *timezones
+	// names of the channels and guilds
+	names *names
 
 	// client for communicating with discord API
 	client *discordgo.Session
+
+	// inFlight tracks the memos being handled, to drain them on shutdown
+	inFlight service.InFlight
+
+	// reminders of future memos not posted yet
+	reminders *service.Reminders
 }
 
 // Name returns the basic name of this service
-func (d DiscordService) Name() string {
+func (d *DiscordService) Name() string {
 	return "discord"
 }
 
+// roles returns the IDs and names of the given guild roles
+func roles(s *discordgo.Session, guildID string, ids []string) []string {
+	var names []string
+	for _, id := range ids {
+		names = append(names, id)
+		if role, err := s.State.Role(guildID, id); err == nil {
+			names = append(names, role.Name)
+		}
+	}
+	return names
+}
+
+// permalink returns the link to the message
+func permalink(guildID, channelID, messageID string) string {
+	if guildID == "" {
+		guildID = "@me"
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
+
+// channelTags returns the channel name and guild the memo is tagged with:
+// their names, or their IDs if configured. The channel name is empty for
+// the ID to be used, the guild is empty for direct messages
+func (d *DiscordService) channelTags(channelName, channelID, guildID string) (string, string) {
+	if d.config.IDs {
+		return "", guildID
+	}
+	if guildID == "" {
+		return channelName, ""
+	}
+	guild := d.names.Guild(guildID)
+	if guild == "" {
+		guild = guildID
+	}
+	return channelName, guild
+}
+
+// timezoneCommand returns the zone of a "memo tz <zone>" message. Only
+// known zones make it a command, so memos like "memo tz migration" are not
+func (d *DiscordService) timezoneCommand(msg parser.Message) (string, bool) {
+	text, ok, _ := d.parser.Trigger(msg)
+	words := strings.Fields(text)
+	if !ok || len(words) != 2 || !strings.EqualFold(words[0], "tz") {
+		return "", false
+	}
+	if _, err := time.LoadLocation(words[1]); err != nil || words[1] == "Local" {
+		return "", false
+	}
+	return words[1], true
+}
+
+// mentions returns how the bot can be @-mentioned
+func mentions(s *discordgo.Session) []string {
+	if s.State.User == nil {
+		return nil
+	}
+	return []string{"<@" + s.State.User.ID + ">", "<@!" + s.State.User.ID + ">"}
+}
+
+// setTimezone sets the time zone of the user, which clock times in their
+// memos are in, if the user may create memos
+func (d *DiscordService) setTimezone(s *discordgo.Session, m *discordgo.MessageCreate, subject auth.Subject, zone string) {
+	userID := m.Author.ID
+	if m.Member != nil {
+		subject.Groups = roles(s, m.GuildID, m.Member.Roles)
+	}
+	err := d.policy.Authorize(auth.ActionCreate, subject, "")
+	if err != nil {
+		d.replyPrivately(userID, err.Error())
+		return
+	}
+	err = d.limiter.Allow("discord", m.ChannelID, userID)
+	if err != nil {
+		d.replyPrivately(userID, err.Error())
+		return
+	}
+
+	loc, err := d.timezones.Set(userID, zone)
+	if err != nil {
+		d.replyPrivately(userID, err.Error())
+		return
+	}
+	d.replyPrivately(userID, fmt.Sprintf("Your time zone is now %s", loc))
+}
+
+// replyPrivately sends a direct message to the user, as discord has no
+// ephemeral replies to regular messages
+func (d *DiscordService) replyPrivately(userID, text string) {
+	ch, err := d.client.UserChannelCreate(userID)
+	if err != nil {
+		log.Errorf("could not open direct message channel to discord user %s: %s", userID, err.Error())
+		return
+	}
+	d.client.ChannelMessageSend(ch.ID, text)
+}
+
+// queueMessage queues the discord message event for the pool, which
+// handles the messages of a channel in order
+func (d *DiscordService) queueMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author.Bot {
+		return
+	}
+
+	if !d.inFlight.Begin() {
+		log.Debugf("shutting down, dropping discord message %s", m.ID)
+		return
+	}
+
+	err := d.pool.Submit("discord", m.ChannelID, func() {
+		defer d.inFlight.Done()
+		d.handle(s, m)
+	})
+	if err != nil {
+		d.inFlight.Done()
+		log.Warnf("dropping discord message %s in %s: %s", m.ID, m.ChannelID, err)
+	}
+}
+
 // handleMessage takes the discord message event and creates the memo, to pass
 // to the store for storing the memo
 func (d *DiscordService) handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author.Bot {
+	subject := auth.Subject{
+		Source:    "discord",
+		UserID:    m.Author.ID,
+		UserName:  m.Author.Username,
+		ChannelID: m.ChannelID,
+	}
+	subject.ChannelName = d.names.Channel(m.ChannelID)
+	if !d.policy.Listens(subject) {
+		return
+	}
+
+	msg := parser.Message{
+		Text:     m.Content,
+		Time:     m.Timestamp,
+		Location: d.timezones.Get(m.Author.ID, m.Author.Username),
+		Channels: []string{m.ChannelID, subject.ChannelName},
+		Mentions: mentions(s),
+	}
+
+	if zone, ok := d.timezoneCommand(msg); ok {
+		d.setTimezone(s, m, subject, zone)
 		return
 	}
 
+	// the channel name labels the metrics, as for slack. the ID if unknown
+	ch := subject.ChannelName
+	if ch == "" {
+		ch = m.ChannelID
+	}
+
 	log.Debugf("new discord message: %v", m.Content)
-	memo, err := d.parser.Parse(m.Content)
+	metrics.MessagesReceived.WithLabelValues("discord", ch).Inc()
+
+	memos, err := d.parser.ParseAll(msg)
 	if err != nil {
 		if err.Error() != mem.ErrEmpty.Error() {
-			d.client.ChannelMessageSend(m.ChannelID, fmt.Sprintf("memo failed: %s", err.Error()))
+			metrics.MemosRejected.WithLabelValues("discord", ch, metrics.ReasonParse).Inc()
+			d.client.ChannelMessageSend(m.ChannelID, fmt.Sprintf("memo failed: %s", service.Failed(err)))
 		}
 
 		return
 	}
 
-	if memo == nil {
+	if len(memos) == 0 {
 		return
 	}
+	metrics.MemosParsed.WithLabelValues("discord", ch).Add(float64(len(memos)))
 
-	tags := []string{
-		"author:" + m.Author.Username,
-		"chan:" + m.ChannelID,
-		"source:discord",
+	if m.Member != nil {
+		subject.Groups = roles(s, m.GuildID, m.Member.Roles)
+	}
+	err = d.policy.Authorize(auth.ActionCreate, subject, "")
+	if err != nil {
+		metrics.MemosRejected.WithLabelValues("discord", ch, metrics.ReasonDenied).Inc()
+		d.replyPrivately(m.Author.ID, err.Error())
+		return
 	}
 
-	memo.BuildTags(tags)
+	key := dedup.NewKey("discord", m.ID, m.ChannelID, m.Author.ID, m.Content)
+	err = d.dedup.Claim(key)
+	if err != nil {
+		metrics.MemosRejected.WithLabelValues("discord", ch, metrics.ReasonDuplicate).Inc()
+		if dup, ok := err.(*dedup.DuplicateError); ok && !dup.Redelivery {
+			d.replyPrivately(m.Author.ID, err.Error())
+		}
+		return
+	}
 
-	err = d.store.Save(*memo)
+	err = d.limiter.Allow("discord", m.ChannelID, m.Author.ID)
 	if err != nil {
-		d.client.ChannelMessageSend(m.ChannelID, fmt.Sprintf("memo failed: %s", err.Error()))
+		d.dedup.Release(key)
+		metrics.MemosRejected.WithLabelValues("discord", ch, metrics.ReasonRateLimited).Inc()
+		d.replyPrivately(m.Author.ID, err.Error())
 		return
 	}
 
-	d.client.ChannelMessageSend(m.ChannelID, "Memo saved!")
+	var replies []string
+	defer func() {
+		d.client.ChannelMessageSend(m.ChannelID, strings.Join(replies, "\n"))
+	}()
+	markup := newMarkup(s, m.Message, d.names)
+	channel, guild := d.channelTags(subject.ChannelName, m.ChannelID, m.GuildID)
+	for i, memo := range memos {
+		memo.Desc = markup.Normalize(memo.Desc)
+		memo.Body = markup.Normalize(memo.Body)
+		memo.Author = mem.Author{ID: m.Author.ID, Name: m.Author.Username}
+		memo.Channel = mem.Channel{ID: m.ChannelID, Name: channel}
+		if guild != "" {
+			memo.Tags.AddSystem("guild", guild)
+		}
+		memo.Source = "discord"
+		memo.MessageID = m.ID
+		memo.Permalink = permalink(m.GuildID, m.ChannelID, m.ID)
+		memo.Tags.AddChannel(d.tags.ChannelDefaults(m.ChannelID, subject.ChannelName)...)
+
+		err = d.store.Save(d.inFlight.Context(), *memo)
+		if err != nil {
+			// only allow a retry if nothing was saved yet
+			if i == 0 {
+				d.dedup.Release(key)
+			}
+			metrics.MemosRejected.WithLabelValues("discord", ch, metrics.ReasonStore).Add(float64(len(memos) - i))
+			replies = append(replies, service.Unsaved(memos[i:], i, err))
+			return
+		}
+
+		metrics.MemosSaved.WithLabelValues("discord", ch).Inc()
+		replies = append(replies, service.Saved(memo))
+
+		if d.config.Reminders && memo.Date.After(time.Now()) {
+			d.reminders.Schedule("discord", m.ChannelID, *memo)
+		}
+	}
 }
 
 // New creates a new instance of this service
-func New(config cfg.Discord, parser parser.Parser, store store.Store) (service.Service, error) {
+func New(config cfg.Discord, deps service.Deps) (service.Service, error) {
 	client, err := discordgo.New("Bot " + config.BotToken)
 	if err != nil {
-		log.Fatalf("error connecting to discord: %s", err.Error())
+		return nil, fmt.Errorf("error creating discord session: %s", err)
+	}
+
+	timezones, err := loadTimezones(config.Timezones, config.TimezonesPath)
+	if err != nil {
+		return nil, err
 	}
 
-	d := DiscordService{
-		config: config,
-		parser: parser,
-		store:  store,
-		client: client,
+	d := &DiscordService{
+		config:  config,
+		parser:  deps.Parser,
+		store:   deps.Store,
+		policy:  deps.Policy,
+		limiter: deps.Limiter,
+		dedup:   deps.Dedup,
+		tags:    deps.Tags,
+		pool:    deps.Pool,
+		client:  client,
+
+		reminders: deps.Reminders,
+
+		timezones: timezones,
+		names:     newNames(client),
+
+		inFlight: service.InFlight{Source: "discord"},
 	}
 
-	d.client.AddHandler(d.handleMessage)
+	d.handle = d.handleMessage
+
+	// handle events in order, so the messages of a channel are queued in
+	// the order they were sent. Submit only blocks on a full queue
+	d.client.SyncEvents = true
+	d.client.AddHandler(d.queueMessage)
+	d.client.AddHandler(d.names.channelUpdate)
+	d.client.AddHandler(d.names.channelDelete)
+	d.client.AddHandler(d.names.guildUpdate)
+	d.client.AddHandler(d.names.guildDelete)
+	d.client.Identify.Intents |= discordgo.IntentsGuilds
 	d.client.Identify.Intents |= discordgo.IntentsGuildMessages
 	d.client.Identify.Intents |= discordgo.IntentMessageContent
 
-	go func() {
-		err := d.client.Open()
-		if err != nil {
-			log.Fatalf("discord connection failed: %s", err.Error())
-		}
-	}()
-
 	return d, nil
 }
+
+// Check verifies the bot token of config with the discord API, giving up
+// after timeout
+func Check(config cfg.Discord, timeout time.Duration) error {
+	client, err := discordgo.New("Bot " + config.BotToken)
+	if err != nil {
+		return fmt.Errorf("error creating discord session: %s", err)
+	}
+	client.Client.Timeout = timeout
+
+	user, err := client.User("@me")
+	if err != nil {
+		return fmt.Errorf("bot token rejected: %s", err)
+	}
+	log.Debugf("discord bot token belongs to %s", user.Username)
+
+	return nil
+}
+
+// Run opens the discord session and keeps it open until ctx is cancelled.
+// discordgo reconnects by itself after the session is established
+func (d *DiscordService) Run(ctx context.Context, status *service.Status) error {
+	removeConnect := d.client.AddHandler(func(_ *discordgo.Session, _ *discordgo.Connect) {
+		status.Set(service.StateConnected)
+	})
+	defer removeConnect()
+	removeDisconnect := d.client.AddHandler(func(_ *discordgo.Session, _ *discordgo.Disconnect) {
+		status.Set(service.StateConnecting)
+	})
+	defer removeDisconnect()
+
+	err := d.client.Open()
+	if err != nil {
+		return fmt.Errorf("discord connection failed: %s", err)
+	}
+	status.Set(service.StateConnected)
+
+	if d.config.Reminders {
+		d.reminders.Register("discord", d)
+	}
+
+	<-ctx.Done()
+
+	err = d.client.Close()
+	if err != nil {
+		return fmt.Errorf("closing discord session failed: %s", err)
+	}
+
+	return nil
+}
+
+// Drain stops handling new messages and waits for the memos being handled.
+// Reminders not posted yet are kept, for the service that replaces this one
+func (d *DiscordService) Drain(ctx context.Context) error {
+	d.reminders.Unregister("discord", d)
+	return d.inFlight.Drain(ctx)
+}
+
+// Remind posts the reminder of memo to channel
+func (d *DiscordService) Remind(channel string, memo mem.Memo) error {
+	_, err := d.client.ChannelMessageSend(channel, service.Reminder(&memo))
+	return err
+}
@@ -0,0 +1,96 @@
+package discord
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/parser"
+	"github.com/grafana/memo/service"
+)
+
+func TestNewSyncEvents(t *testing.T) {
+	svc, err := New(cfg.Discord{BotToken: "token"}, service.Deps{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with async events, messages race each other to the pool and the
+	// memos of a channel are saved out of order
+	if !svc.(*DiscordService).client.SyncEvents {
+		t.Fatal("exp discord events to be handled in order")
+	}
+}
+
+func TestTimezoneCommand(t *testing.T) {
+	d := &DiscordService{parser: parser.New()}
+
+	cases := []struct {
+		text   string
+		expCmd bool
+	}{
+		{"memo tz Europe/Amsterdam", true},
+		{"memo tz UTC", true},
+		// memos about tz are saved, not taken as a command
+		{"memo tz migration", false},
+		{"memo tz Local", false},
+		{"memo tz rollout done", false},
+	}
+	for _, c := range cases {
+		_, ok := d.timezoneCommand(parser.Message{Text: c.text})
+		if ok != c.expCmd {
+			t.Errorf("%q: exp command=%t, got %t", c.text, c.expCmd, ok)
+		}
+	}
+}
+
+func TestQueueMessageOrder(t *testing.T) {
+	pool := service.NewPool(cfg.Workers{Count: 4, QueueSize: 100})
+	defer pool.Stop(context.Background())
+
+	svc, err := New(cfg.Discord{BotToken: "token"}, service.Deps{Pool: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := svc.(*DiscordService)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	got := map[string][]string{}
+	d.handle = func(_ *discordgo.Session, m *discordgo.MessageCreate) {
+		defer wg.Done()
+		// later messages finishing first must not overtake earlier ones
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		mu.Lock()
+		got[m.ChannelID] = append(got[m.ChannelID], m.ID)
+		mu.Unlock()
+	}
+
+	// the events arrive one by one, as SyncEvents is set
+	var exp []string
+	for i := 0; i < 20; i++ {
+		id := strconv.Itoa(i)
+		exp = append(exp, id)
+		for _, ch := range []string{"C1", "C2"} {
+			wg.Add(1)
+			d.queueMessage(d.client, &discordgo.MessageCreate{Message: &discordgo.Message{
+				ID:        id,
+				ChannelID: ch,
+				Author:    &discordgo.User{ID: "U1"},
+			}})
+		}
+	}
+	wg.Wait()
+
+	for _, ch := range []string{"C1", "C2"} {
+		if !reflect.DeepEqual(got[ch], exp) {
+			t.Errorf("%s: exp messages in order %v, got %v", ch, exp, got[ch])
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package discord
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	// userRe matches user mentions, <@123> and <@!123>
+	userRe = regexp.MustCompile(`<@!?(\d+)>`)
+	// roleRe matches role mentions, <@&123>
+	roleRe = regexp.MustCompile(`<@&(\d+)>`)
+	// channelRe matches channel links, <#123>
+	channelRe = regexp.MustCompile(`<#(\d+)>`)
+	// emojiRe matches custom emoji, <:name:123> and animated <a:name:123>
+	emojiRe = regexp.MustCompile(`<a?:(\w+):\d+>`)
+	// timestampRe matches timestamps, <t:1714550400> and <t:1714550400:R>
+	timestampRe = regexp.MustCompile(`<t:(-?\d+)(?::[tTdDfFR])?>`)
+)
+
+// markup converts discord markup in memo text to the Markdown Grafana
+// renders. Discord formatting is Markdown already
+type markup struct {
+	// user returns the name of a user ID
+	user func(id string) string
+	// role returns the name of a role ID
+	role func(id string) string
+	// channel returns the name of a channel ID
+	channel func(id string) string
+}
+
+// newMarkup returns the markup of a message, resolving names with the
+// mentions of the message, the state of the session and the channel names
+func newMarkup(s *discordgo.Session, m *discordgo.Message, names *names) markup {
+	return markup{
+		user: func(id string) string {
+			for _, u := range m.Mentions {
+				if u.ID == id {
+					return u.Username
+				}
+			}
+			if member, err := s.State.Member(m.GuildID, id); err == nil && member.User != nil {
+				return member.User.Username
+			}
+			return id
+		},
+		role: func(id string) string {
+			if role, err := s.State.Role(m.GuildID, id); err == nil {
+				return role.Name
+			}
+			return id
+		},
+		channel: func(id string) string {
+			if name := names.Channel(id); name != "" {
+				return name
+			}
+			return id
+		},
+	}
+}
+
+// Normalize resolves the users, roles and channels in text into readable
+// names, and converts custom emoji and timestamps to text
+func (m markup) Normalize(text string) string {
+	text = userRe.ReplaceAllStringFunc(text, func(s string) string {
+		return "@" + m.user(userRe.FindStringSubmatch(s)[1])
+	})
+	text = roleRe.ReplaceAllStringFunc(text, func(s string) string {
+		return "@" + m.role(roleRe.FindStringSubmatch(s)[1])
+	})
+	text = channelRe.ReplaceAllStringFunc(text, func(s string) string {
+		return "#" + m.channel(channelRe.FindStringSubmatch(s)[1])
+	})
+	text = emojiRe.ReplaceAllString(text, ":$1:")
+	text = timestampRe.ReplaceAllStringFunc(text, func(s string) string {
+		sec, err := strconv.ParseInt(timestampRe.FindStringSubmatch(s)[1], 10, 64)
+		if err != nil {
+			return s
+		}
+		return time.Unix(sec, 0).UTC().Format("2006-01-02 15:04 UTC")
+	})
+	return text
+}
@@ -0,0 +1,30 @@
+package discord
+
+import "testing"
+
+func TestMarkupNormalize(t *testing.T) {
+	m := markup{
+		user:    func(id string) string { return map[string]string{"1": "alice"}[id] },
+		role:    func(id string) string { return map[string]string{"2": "sre"}[id] },
+		channel: func(id string) string { return map[string]string{"3": "ops"}[id] },
+	}
+
+	cases := []struct {
+		text string
+		exp  string
+	}{
+		{"restarted db", "restarted db"},
+		{"restarted db for <@1> and <@!1>", "restarted db for @alice and @alice"},
+		{"paged <@&2> in <#3>", "paged @sre in #ops"},
+		{"fixed <:party:123> <a:dance:456>", "fixed :party: :dance:"},
+		{"down since <t:1714550400:R>", "down since 2024-05-01 08:00 UTC"},
+		{"**kept** as `is`", "**kept** as `is`"},
+	}
+
+	for i, c := range cases {
+		got := m.Normalize(c.text)
+		if got != c.exp {
+			t.Errorf("case %d: %q: exp %q, got %q", i, c.text, c.exp, got)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package discord
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// names caches the names of discord channels and guilds, which are only
+// known by ID in messages. Renamed and deleted channels and guilds are
+// forgotten, so their next lookup gets the new name
+type names struct {
+	// mu protects channels and guilds
+	mu sync.Mutex
+
+	// channels and guilds map IDs to names
+	channels map[string]string
+	guilds   map[string]string
+
+	// lookupChannel and lookupGuild return the name of an ID from discord
+	lookupChannel func(id string) (string, error)
+	lookupGuild   func(id string) (string, error)
+}
+
+// newNames returns the names of the channels and guilds of the session,
+// from its state, or the discord API if they are not in it
+func newNames(s *discordgo.Session) *names {
+	return &names{
+		channels: make(map[string]string),
+		guilds:   make(map[string]string),
+		lookupChannel: func(id string) (string, error) {
+			c, err := s.State.Channel(id)
+			if err != nil {
+				c, err = s.Channel(id)
+			}
+			if err != nil {
+				return "", err
+			}
+			return c.Name, nil
+		},
+		lookupGuild: func(id string) (string, error) {
+			g, err := s.State.Guild(id)
+			if err != nil {
+				g, err = s.Guild(id)
+			}
+			if err != nil {
+				return "", err
+			}
+			return g.Name, nil
+		},
+	}
+}
+
+// Channel returns the name of the channel, or an empty string if it has
+// none, like direct messages, or it could not be looked up
+func (n *names) Channel(id string) string {
+	return n.get(n.channels, n.lookupChannel, "channel", id)
+}
+
+// Guild returns the name of the guild, or an empty string if it could not
+// be looked up
+func (n *names) Guild(id string) string {
+	if id == "" {
+		return ""
+	}
+	return n.get(n.guilds, n.lookupGuild, "guild", id)
+}
+
+// get returns the name of id from cache, looking it up if it is not in it.
+// failed lookups are not cached, so they are retried
+func (n *names) get(cache map[string]string, lookup func(string) (string, error), kind, id string) string {
+	n.mu.Lock()
+	name, ok := cache[id]
+	n.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	name, err := lookup(id)
+	if err != nil {
+		log.Warnf("could not look up the name of discord %s %s: %s", kind, id, err)
+		return ""
+	}
+
+	n.mu.Lock()
+	cache[id] = name
+	n.mu.Unlock()
+	return name
+}
+
+// forget removes id from cache
+func (n *names) forget(cache map[string]string, id string) {
+	n.mu.Lock()
+	delete(cache, id)
+	n.mu.Unlock()
+}
+
+// channelUpdate forgets the name of a renamed channel
+func (n *names) channelUpdate(_ *discordgo.Session, c *discordgo.ChannelUpdate) {
+	n.forget(n.channels, c.ID)
+}
+
+// channelDelete forgets the name of a deleted channel
+func (n *names) channelDelete(_ *discordgo.Session, c *discordgo.ChannelDelete) {
+	n.forget(n.channels, c.ID)
+}
+
+// guildUpdate forgets the name of a renamed guild
+func (n *names) guildUpdate(_ *discordgo.Session, g *discordgo.GuildUpdate) {
+	n.forget(n.guilds, g.ID)
+}
+
+// guildDelete forgets the name of a guild the bot left
+func (n *names) guildDelete(_ *discordgo.Session, g *discordgo.GuildDelete) {
+	n.forget(n.guilds, g.ID)
+}
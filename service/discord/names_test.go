@@ -0,0 +1,54 @@
+package discord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestNames(t *testing.T) {
+	channels := map[string]string{"1": "ops"}
+	lookups := 0
+	n := &names{
+		channels: make(map[string]string),
+		guilds:   make(map[string]string),
+		lookupChannel: func(id string) (string, error) {
+			lookups++
+			name, ok := channels[id]
+			if !ok {
+				return "", errors.New("unknown channel")
+			}
+			return name, nil
+		},
+		lookupGuild: func(id string) (string, error) { return "grafana", nil },
+	}
+
+	if got := n.Channel("1"); got != "ops" {
+		t.Fatalf("exp ops, got %q", got)
+	}
+	channels["1"] = "incidents"
+	if got := n.Channel("1"); got != "ops" || lookups != 1 {
+		t.Fatalf("exp cached ops after 1 lookup, got %q after %d", got, lookups)
+	}
+
+	n.channelUpdate(nil, &discordgo.ChannelUpdate{Channel: &discordgo.Channel{ID: "1"}})
+	if got := n.Channel("1"); got != "incidents" {
+		t.Fatalf("exp incidents after rename, got %q", got)
+	}
+
+	if got := n.Channel("2"); got != "" {
+		t.Fatalf("exp no name for unknown channel, got %q", got)
+	}
+	n.Channel("2")
+	if lookups != 4 {
+		t.Fatalf("exp failed lookups to be retried, got %d lookups", lookups)
+	}
+
+	if got := n.Guild(""); got != "" {
+		t.Fatalf("exp no guild for direct messages, got %q", got)
+	}
+	if got := n.Guild("3"); got != "grafana" {
+		t.Fatalf("exp grafana, got %q", got)
+	}
+}
@@ -0,0 +1,123 @@
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// timezones are the time zones of discord users, as discord does not share
+// them. users set theirs with "memo tz <zone>"
+type timezones struct {
+	// mu protects set
+	mu sync.Mutex
+
+	// path where set is kept. set is only kept in memory if empty
+	path string
+	// static are the zones from the config, by user ID or name
+	static map[string]string
+	// set are the zones users set themselves, by user ID
+	set map[string]string
+}
+
+// loadTimezones returns the time zones from the config, and those kept in
+// path by earlier runs
+func loadTimezones(static map[string]string, path string) (*timezones, error) {
+	t := &timezones{
+		path:   path,
+		static: static,
+		set:    make(map[string]string),
+	}
+	if path == "" {
+		return t, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read discord timezones: %s", err)
+	}
+	err = json.Unmarshal(data, &t.set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse discord timezones %s: %s", path, err)
+	}
+
+	return t, nil
+}
+
+// Get returns the time zone of the user, or nil if it is not known. The
+// zone the user set takes precedence over the config
+func (t *timezones) Get(userID, userName string) *time.Location {
+	t.mu.Lock()
+	zone, ok := t.set[userID]
+	t.mu.Unlock()
+
+	if !ok {
+		zone, ok = t.static[userID]
+	}
+	if !ok {
+		zone, ok = t.static[userName]
+	}
+	if !ok {
+		return nil
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// Set sets the time zone of the user, and keeps it in path. If keeping it
+// fails, the zone is still used until memod restarts
+func (t *timezones) Set(userID, zone string) (*time.Location, error) {
+	loc, err := time.LoadLocation(zone)
+	if err != nil || zone == "" || zone == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q, use a zone name such as Europe/Amsterdam", zone)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.set[userID] = loc.String()
+	if t.path == "" {
+		return loc, nil
+	}
+
+	err = t.save()
+	if err != nil {
+		log.Errorf("failed to save discord time zones in %s: %s", t.path, err)
+	}
+
+	return loc, nil
+}
+
+// save writes the zones users set to path. t.mu must be held
+func (t *timezones) save() error {
+	data, err := json.MarshalIndent(t.set, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(t.path), ".timezones")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), t.path)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/memo/metrics"
+)
+
+// InFlight tracks the memos a service is currently handling, so that they
+// can be drained on shutdown
+type InFlight struct {
+	// Source is the name of the service, used as metrics label
+	Source string
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	stopping bool
+
+	// ctx is cancelled when draining gives up, to abort the memos in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Context returns the context of the memos in flight. It is cancelled when
+// Drain gives up waiting for them
+func (f *InFlight) Context() context.Context {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ctx == nil {
+		f.ctx, f.cancel = context.WithCancel(context.Background())
+	}
+	return f.ctx
+}
+
+// Begin registers a new message for handling. It returns false once
+// draining has started, in which case the message must be dropped
+func (f *InFlight) Begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stopping {
+		return false
+	}
+	f.wg.Add(1)
+	metrics.OutboxDepth.WithLabelValues(f.Source).Inc()
+	return true
+}
+
+// Done marks a message registered with Begin as handled
+func (f *InFlight) Done() {
+	metrics.OutboxDepth.WithLabelValues(f.Source).Dec()
+	f.wg.Done()
+}
+
+// Drain stops new messages from being accepted and waits for the ones in
+// flight to finish, or for ctx to expire
+func (f *InFlight) Drain(ctx context.Context) error {
+	f.mu.Lock()
+	f.stopping = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		f.mu.Lock()
+		if f.cancel != nil {
+			f.cancel()
+		}
+		f.mu.Unlock()
+		return fmt.Errorf("gave up waiting for in-flight memos: %s", ctx.Err())
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/metrics"
+)
+
+const (
+	// DefaultWorkers is the number of workers when none is configured
+	DefaultWorkers = 4
+	// DefaultQueueSize is the queue size of each worker when none is
+	// configured
+	DefaultQueueSize = 100
+	// DefaultMaxWait is how long Submit waits for room when none is
+	// configured. it holds up the events of the service, so it is short
+	DefaultMaxWait = time.Second
+)
+
+// ErrQueueFull is returned by Submit when the queue stayed full
+var ErrQueueFull = errors.New("too many memos are waiting to be saved, try again later")
+
+// ErrPoolStopped is returned by Submit once the pool is stopped
+var ErrPoolStopped = errors.New("worker pool stopped")
+
+// job is a message queued for a worker
+type job struct {
+	// source of the message, used as metrics label
+	source string
+	// queued is when the job was submitted
+	queued time.Time
+	// run handles the message
+	run func()
+}
+
+// Pool is a bounded set of workers handling messages between the services
+// and the store. Messages of a channel always go to the same worker, so
+// they are handled in order. It is safe for concurrent use
+type Pool struct {
+	// queues of the workers
+	queues []chan job
+	// maxWait is how long Submit waits for room in a full queue
+	maxWait time.Duration
+
+	// quit is closed by Stop
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewPool starts the workers of config
+func NewPool(config cfg.Workers) *Pool {
+	count := config.Count
+	if count == 0 {
+		count = DefaultWorkers
+	}
+	size := config.QueueSize
+	if size == 0 {
+		size = DefaultQueueSize
+	}
+
+	p := &Pool{
+		queues:  make([]chan job, count),
+		maxWait: config.MaxWait.Or(DefaultMaxWait),
+		quit:    make(chan struct{}),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan job, size)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// work runs the jobs of queue until the pool is stopped
+func (p *Pool) work(queue chan job) {
+	defer p.wg.Done()
+	for {
+		select {
+		case j := <-queue:
+			metrics.QueueWait.WithLabelValues(j.source).Observe(time.Since(j.queued).Seconds())
+			j.run()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Submit queues run to handle a message from source in channel, after the
+// messages of the channel submitted before it. If the queue is full, it
+// waits for room for up to the configured max wait, then returns
+// ErrQueueFull
+func (p *Pool) Submit(source, channel string, run func()) error {
+	return p.submit(source, channel, run, p.maxWait)
+}
+
+// TrySubmit is like Submit, but returns ErrQueueFull right away if the
+// queue is full. For services that get the message again later
+func (p *Pool) TrySubmit(source, channel string, run func()) error {
+	return p.submit(source, channel, run, 0)
+}
+
+// submit queues run, waiting up to wait for room
+func (p *Pool) submit(source, channel string, run func(), wait time.Duration) error {
+	h := fnv.New32a()
+	h.Write([]byte(source + "/" + channel))
+	queue := p.queues[h.Sum32()%uint32(len(p.queues))]
+
+	select {
+	case <-p.quit:
+		return ErrPoolStopped
+	default:
+	}
+
+	j := job{source: source, queued: time.Now(), run: run}
+	select {
+	case queue <- j:
+		return nil
+	default:
+	}
+	if wait <= 0 {
+		metrics.MessagesDropped.WithLabelValues(source).Inc()
+		return ErrQueueFull
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-p.quit:
+		return ErrPoolStopped
+	case queue <- j:
+		return nil
+	case <-timer.C:
+		metrics.MessagesDropped.WithLabelValues(source).Inc()
+		return ErrQueueFull
+	}
+}
+
+// Stop stops the workers after the jobs they are running, or until ctx
+// expires. Jobs still queued are dropped, so the services should be
+// drained first
+func (p *Pool) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.quit)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for the workers: %s", ctx.Err())
+	}
+}
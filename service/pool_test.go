@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/memo/cfg"
+)
+
+func TestPoolOrder(t *testing.T) {
+	p := NewPool(cfg.Workers{Count: 4, QueueSize: 10})
+	defer p.Stop(context.Background())
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	got := map[string][]int{}
+	for i := 0; i < 20; i++ {
+		for _, ch := range []string{"C1", "C2", "C3"} {
+			i, ch := i, ch
+			wg.Add(1)
+			err := p.Submit("slack", ch, func() {
+				defer wg.Done()
+				mu.Lock()
+				got[ch] = append(got[ch], i)
+				mu.Unlock()
+			})
+			if err != nil {
+				t.Fatalf("submit %s %d: %s", ch, i, err)
+			}
+		}
+	}
+	wg.Wait()
+
+	for ch, order := range got {
+		for i, n := range order {
+			if n != i {
+				t.Fatalf("%s: exp memos in order, got %v", ch, order)
+			}
+		}
+	}
+}
+
+func TestPoolFull(t *testing.T) {
+	p := NewPool(cfg.Workers{Count: 1, QueueSize: 1, MaxWait: cfg.Duration{Duration: 10 * time.Millisecond}})
+
+	block := make(chan struct{})
+	running := make(chan struct{})
+	p.Submit("slack", "C1", func() {
+		close(running)
+		<-block
+	})
+	<-running
+
+	if err := p.Submit("slack", "C1", func() {}); err != nil {
+		t.Fatalf("exp room for one queued job, got %s", err)
+	}
+	if err := p.Submit("slack", "C1", func() {}); err != ErrQueueFull {
+		t.Fatalf("exp ErrQueueFull, got %v", err)
+	}
+	if err := p.TrySubmit("slack", "C1", func() {}); err != ErrQueueFull {
+		t.Fatalf("exp ErrQueueFull without waiting, got %v", err)
+	}
+
+	close(block)
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error stopping: %s", err)
+	}
+	if err := p.Submit("slack", "C1", func() {}); err != ErrPoolStopped {
+		t.Fatalf("exp ErrPoolStopped, got %v", err)
+	}
+}
+
+func TestPoolStopTimeout(t *testing.T) {
+	p := NewPool(cfg.Workers{Count: 1})
+
+	block := make(chan struct{})
+	defer close(block)
+	running := make(chan struct{})
+	p.Submit("slack", "C1", func() {
+		close(running)
+		<-block
+	})
+	<-running
+
+	// a hanging job must not hold up the shutdown past its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Stop(ctx); err == nil {
+		t.Fatalf("exp an error when the running job outlasts ctx")
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/memo"
+	log "github.com/sirupsen/logrus"
+)
+
+// Poster posts the reminder of a memo to a channel
+type Poster interface {
+	// Remind posts the reminder of memo to channel
+	Remind(channel string, memo memo.Memo) error
+}
+
+// Reminders posts reminders when future memos start. The zero value is
+// ready to use. Reminders are posted by the service registered for their
+// source when they are due, so they survive a restart of the service
+type Reminders struct {
+	// mu protects timers, posters and stopped
+	mu sync.Mutex
+	// timers of the reminders not posted yet
+	timers map[*time.Timer]struct{}
+	// posters are the services posting reminders, by source
+	posters map[string]Poster
+	// stopped is set once no more reminders are posted
+	stopped bool
+}
+
+// Register makes poster post the reminders of source, replacing the service
+// registered before
+func (r *Reminders) Register(source string, poster Poster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.posters == nil {
+		r.posters = make(map[string]Poster)
+	}
+	r.posters[source] = poster
+}
+
+// Unregister stops poster from posting the reminders of source, if it is
+// still the registered one. Reminders due without a registered service are
+// dropped
+func (r *Reminders) Unregister(source string, poster Poster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.posters[source] == poster {
+		delete(r.posters, source)
+	}
+}
+
+// Schedule posts the reminder of memo to the channel of source when the
+// memo starts, unless the reminders are stopped before then
+func (r *Reminders) Schedule(source, channel string, memo memo.Memo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return
+	}
+	if r.timers == nil {
+		r.timers = make(map[*time.Timer]struct{})
+	}
+
+	// the timer only fires once it is stored, as that needs the lock
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(memo.Date), func() {
+		r.mu.Lock()
+		delete(r.timers, timer)
+		stopped := r.stopped
+		poster := r.posters[source]
+		r.mu.Unlock()
+
+		if stopped {
+			return
+		}
+		if poster == nil {
+			log.Warnf("dropping reminder of %q in %s: %s is stopped or has reminders disabled", memo.Desc, channel, source)
+			return
+		}
+		err := poster.Remind(channel, memo)
+		if err != nil {
+			log.Errorf("could not post reminder of %q in %s: %s", memo.Desc, channel, err)
+		}
+	})
+	r.timers[timer] = struct{}{}
+}
+
+// Stop cancels the reminders not posted yet. Reminders are kept in memory
+// only, so they are lost when memod stops
+func (r *Reminders) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopped = true
+	for timer := range r.timers {
+		timer.Stop()
+	}
+	r.timers = nil
+}
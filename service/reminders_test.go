@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/memo"
+)
+
+// testPoster sends its name and the channel of the reminders it posts
+type testPoster struct {
+	name   string
+	posted chan string
+}
+
+func (p *testPoster) Remind(channel string, memo memo.Memo) error {
+	p.posted <- p.name + " " + channel
+	return nil
+}
+
+func TestRemindersPostWithCurrentService(t *testing.T) {
+	var r Reminders
+	defer r.Stop()
+
+	posted := make(chan string, 2)
+	old := &testPoster{name: "old", posted: posted}
+	current := &testPoster{name: "current", posted: posted}
+
+	r.Register("slack", old)
+	r.Schedule("slack", "C1", memo.Memo{Date: time.Now().Add(20 * time.Millisecond), Desc: "deploy"})
+
+	// the service is restarted before the reminder is due
+	r.Register("slack", current)
+	r.Unregister("slack", old)
+
+	select {
+	case got := <-posted:
+		if got != "current C1" {
+			t.Fatalf("exp the reminder posted by the current service, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("exp the reminder to be posted")
+	}
+
+	// without a registered service, reminders are dropped
+	r.Unregister("slack", current)
+	r.Schedule("slack", "C1", memo.Memo{Date: time.Now().Add(10 * time.Millisecond), Desc: "deploy"})
+	select {
+	case got := <-posted:
+		t.Fatalf("exp the reminder to be dropped, got %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/grafana/memo"
+	"github.com/grafana/memo/parser"
+)
+
+// timeFormat is how times are shown in replies
+const timeFormat = "2006-01-02 15:04:05 MST"
+
+// Saved returns the reply to a saved memo, echoing its time in UTC so
+// users can check how their timespec was understood
+func Saved(m *memo.Memo) string {
+	if m.End.After(m.Date) {
+		return fmt.Sprintf("Memo saved from %s to %s", m.Date.UTC().Format(timeFormat), m.End.UTC().Format(timeFormat))
+	}
+	return fmt.Sprintf("Memo saved at %s", m.Date.UTC().Format(timeFormat))
+}
+
+// Unsaved returns the reply to memos that failed to save, after the ones
+// before them in the message were saved. Those are duplicates if the whole
+// message is posted again, so then only the failed ones should be
+func Unsaved(memos []*memo.Memo, saved int, err error) string {
+	reply := fmt.Sprintf("memo failed: %s", err)
+	if len(memos) > 1 {
+		reply = fmt.Sprintf("%d memos failed, from %q on: %s", len(memos), memos[0].Desc, err)
+	}
+	if saved > 0 {
+		reply += ". the memos before it were saved, repost only the failed ones"
+	}
+	return reply
+}
+
+// Failed returns the reply to a message that could not be parsed, with the
+// corrected message if the parser has a suggestion
+func Failed(err error) string {
+	var perr *parser.ParseError
+	if errors.As(err, &perr) && perr.Suggestion != "" {
+		return fmt.Sprintf("%s. did you mean `%s`?", perr.Reason, perr.Suggestion)
+	}
+	return err.Error()
+}
+
+// Reminder returns the reminder posted when a future memo starts
+func Reminder(m *memo.Memo) string {
+	if m.End.After(m.Date) {
+		return fmt.Sprintf("Starting now, until %s: %s", m.End.UTC().Format(timeFormat), m.Desc)
+	}
+	return fmt.Sprintf("Starting now: %s", m.Desc)
+}
@@ -1,6 +1,45 @@
 package service
 
+import (
+	"context"
+
+	"github.com/grafana/memo"
+	"github.com/grafana/memo/auth"
+	"github.com/grafana/memo/dedup"
+	"github.com/grafana/memo/parser"
+	"github.com/grafana/memo/ratelimit"
+	"github.com/grafana/memo/store"
+)
+
 // Service for receiving memo messages
 type Service interface {
 	Name() string
+	// Run connects to the chat service and handles messages until the
+	// connection is lost or ctx is cancelled. It reports connection
+	// changes through status
+	Run(ctx context.Context, status *Status) error
+	// Drain stops accepting new messages and waits for in-flight memos to
+	// be saved and replied to, or for ctx to expire
+	Drain(ctx context.Context) error
+}
+
+// Deps are the components shared by all services, that memos pass
+// through on their way to the store
+type Deps struct {
+	// Parser takes the memo and extracts the values from it
+	Parser parser.Parser
+	// Store puts the memo in the defined store
+	Store store.Store
+	// Policy decides who may create memos, and where
+	Policy *auth.Policy
+	// Limiter rate limits memos by user, channel and source
+	Limiter *ratelimit.Limiter
+	// Dedup suppresses duplicate memos
+	Dedup *dedup.Filter
+	// Tags is the tag policy, providing the channel default tags
+	Tags *memo.TagPolicy
+	// Pool handles the messages, in order per channel
+	Pool *Pool
+	// Reminders of future memos, kept when a service is restarted
+	Reminders *Reminders
 }
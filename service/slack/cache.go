@@ -0,0 +1,76 @@
+package slack
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// namesTTL is how long channel and user names are cached. renames are
+// usually seen as events before that
+const namesTTL = time.Hour
+
+// cache keeps values by ID for a while. It is safe for concurrent use
+type cache struct {
+	// mu protects entries
+	mu sync.Mutex
+
+	// ttl is how long an entry is kept
+	ttl time.Duration
+	// entries by ID
+	entries map[string]cacheEntry
+
+	// now returns the current time, for mocking in tests
+	now func() time.Time
+}
+
+// cacheEntry is a cached value, with when it expires
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// newCache returns an empty cache keeping entries for ttl
+func newCache(ttl time.Duration) *cache {
+	return &cache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// Get returns the value of id, if it is cached and has not expired
+func (c *cache) Get(id string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(e.expires) {
+		delete(c.entries, id)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set caches the value of id. Entries expire up to a tenth of the ttl
+// early, so entries set together are not all looked up again at once
+func (c *cache) Set(id string, value interface{}) {
+	var jitter time.Duration
+	if c.ttl >= 10 {
+		jitter = time.Duration(rand.Int63n(int64(c.ttl / 10)))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[id] = cacheEntry{value: value, expires: c.now().Add(c.ttl - jitter)}
+}
+
+// Forget removes id, so its next Get misses
+func (c *cache) Forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, id)
+}
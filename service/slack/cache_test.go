@@ -0,0 +1,100 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	c := newCache(time.Hour)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.Get("C1"); ok {
+		t.Fatal("exp empty cache to miss")
+	}
+
+	c.Set("C1", "ops")
+	if v, ok := c.Get("C1"); !ok || v.(string) != "ops" {
+		t.Fatalf("exp ops, got %v, %t", v, ok)
+	}
+
+	c.Forget("C1")
+	if _, ok := c.Get("C1"); ok {
+		t.Fatal("exp forgotten entry to miss")
+	}
+
+	// entries expire up to 6m early
+	c.Set("C1", "ops")
+	now = now.Add(54 * time.Minute)
+	if _, ok := c.Get("C1"); !ok {
+		t.Fatal("exp entry to be kept for at least 54m")
+	}
+	now = now.Add(6 * time.Minute)
+	if _, ok := c.Get("C1"); ok {
+		t.Fatal("exp expired entry to miss")
+	}
+}
+
+func TestUserChange(t *testing.T) {
+	cases := []struct {
+		message  string
+		envelope string
+		user     string
+		ok       bool
+	}{
+		{`{"envelope_id":"e1","type":"events_api","payload":{"event":{"type":"user_change","user":{"id":"U1","name":"alice"}}}}`, "e1", "U1", true},
+		{`{"envelope_id":"e2","type":"events_api","payload":{"event":{"type":"team_join","user":{"id":"U1"}}}}`, "", "", false},
+		{`not json`, "", "", false},
+	}
+
+	for i, c := range cases {
+		envelope, user, ok := userChange([]byte(c.message))
+		if envelope != c.envelope || user != c.user || ok != c.ok {
+			t.Errorf("case %d: exp %q %q %t, got %q %q %t", i, c.envelope, c.user, c.ok, envelope, user, ok)
+		}
+	}
+}
+
+func TestWarmCaches(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/conversations.list":
+			fmt.Fprint(w, `{"ok":true,"channels":[{"id":"C1","name":"ops"}],"response_metadata":{"next_cursor":""}}`)
+		case "/users.list":
+			fmt.Fprint(w, `{"ok":true,"members":[{"id":"U1","name":"alice"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s := &SlackService{
+		api:      slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/")),
+		channels: newCache(namesTTL),
+		users:    newCache(namesTTL),
+	}
+
+	// a cancelled run has to warm them again
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if s.warmCaches(ctx) {
+		t.Errorf("exp warming with a cancelled ctx not to finish")
+	}
+
+	if !s.warmCaches(context.Background()) {
+		t.Fatalf("exp warming to finish")
+	}
+	if v, ok := s.channels.Get("C1"); !ok || v.(string) != "ops" {
+		t.Errorf("exp channel C1 cached as ops, got %v", v)
+	}
+	if v, ok := s.users.Get("U1"); !ok || v.(slackUser).name != "alice" {
+		t.Errorf("exp user U1 cached as alice, got %v", v)
+	}
+}
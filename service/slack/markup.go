@@ -0,0 +1,85 @@
+package slack
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// linkRe matches the <...> markup of users, channels, special mentions
+	// and links, with an optional |label
+	linkRe = regexp.MustCompile(`<([^<>|\s]+)(?:\|([^<>]*))?>`)
+	// boldRe, italicRe and strikeRe match mrkdwn emphasis, which must not
+	// be part of a word
+	boldRe   = regexp.MustCompile(`(^|[\s(])\*([^*\n]+)\*($|[\s.,;:!?)])`)
+	italicRe = regexp.MustCompile(`(^|[\s(])_([^_\n]+)_($|[\s.,;:!?)])`)
+	strikeRe = regexp.MustCompile(`(^|[\s(])~([^~\n]+)~($|[\s.,;:!?)])`)
+	// codeRe matches code blocks and inline code, which are left alone
+	codeRe = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+)
+
+// markup converts slack markup in memo text to the Markdown Grafana renders
+type markup struct {
+	// user returns the name of a user ID
+	user func(id string) string
+	// channel returns the name of a channel ID
+	channel func(id string) string
+}
+
+// Normalize resolves the users, channels and links in text into readable
+// names, and converts mrkdwn to Markdown
+func (m markup) Normalize(text string) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range codeRe.FindAllStringIndex(text, -1) {
+		out.WriteString(m.normalizeText(text[last:loc[0]]))
+		out.WriteString(html.UnescapeString(text[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	out.WriteString(m.normalizeText(text[last:]))
+	return out.String()
+}
+
+// normalizeText normalizes text without code
+func (m markup) normalizeText(text string) string {
+	text = linkRe.ReplaceAllStringFunc(text, func(s string) string {
+		parts := linkRe.FindStringSubmatch(s)
+		return m.link(parts[1], parts[2])
+	})
+
+	text = boldRe.ReplaceAllString(text, "$1**$2**$3")
+	text = italicRe.ReplaceAllString(text, "$1*$2*$3")
+	text = strikeRe.ReplaceAllString(text, "$1~~$2~~$3")
+
+	return html.UnescapeString(text)
+}
+
+// link returns the Markdown of the <target|label> markup
+func (m markup) link(target, label string) string {
+	switch {
+	case strings.HasPrefix(target, "@"):
+		if label != "" {
+			return "@" + label
+		}
+		return "@" + m.user(target[1:])
+	case strings.HasPrefix(target, "#"):
+		if label != "" {
+			return "#" + label
+		}
+		return "#" + m.channel(target[1:])
+	case strings.HasPrefix(target, "!"):
+		// <!here>, <!subteam^S123|@team>, <!date^1714550400^{date}|fallback>
+		if label != "" {
+			return label
+		}
+		special := strings.SplitN(target[1:], "^", 2)[0]
+		return "@" + special
+	case label == "" || label == target:
+		return target
+	case strings.HasPrefix(target, "mailto:"):
+		return label
+	default:
+		return "[" + label + "](" + target + ")"
+	}
+}
@@ -0,0 +1,33 @@
+package slack
+
+import "testing"
+
+func TestMarkupNormalize(t *testing.T) {
+	m := markup{
+		user:    func(id string) string { return map[string]string{"U1": "alice"}[id] },
+		channel: func(id string) string { return map[string]string{"C1": "ops"}[id] },
+	}
+
+	cases := []struct {
+		text string
+		exp  string
+	}{
+		{"restarted db", "restarted db"},
+		{"restarted db for <@U1>", "restarted db for @alice"},
+		{"moved to <#C1|ops> from <#C1>", "moved to #ops from #ops"},
+		{"<!here> <!subteam^S1|@sre> db is down", "@here @sre db is down"},
+		{"see <https://example.com/runbook|the runbook> and <https://example.com>", "see [the runbook](https://example.com/runbook) and https://example.com"},
+		{"mail <mailto:ops@example.com|ops@example.com>", "mail ops@example.com"},
+		{"*really* _slowly_ ~not~ done", "**really** *slowly* ~~not~~ done"},
+		{"kept snake_case_name and 2*3*4", "kept snake_case_name and 2*3*4"},
+		{"ran `*x* &lt; 1` then\n```\n_a_ &amp;&amp; b\n```", "ran `*x* < 1` then\n```\n_a_ && b\n```"},
+		{"1 &lt; 2 &amp;&amp; 3 &gt; 2", "1 < 2 && 3 > 2"},
+	}
+
+	for i, c := range cases {
+		got := m.Normalize(c.text)
+		if got != c.exp {
+			t.Errorf("case %d: %q: exp %q, got %q", i, c.text, c.exp, got)
+		}
+	}
+}
@@ -1,12 +1,25 @@
 package slack
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	llog "log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	mem "github.com/grafana/memo"
+	"github.com/grafana/memo/auth"
 	"github.com/grafana/memo/cfg"
+	"github.com/grafana/memo/dedup"
+	"github.com/grafana/memo/metrics"
 	"github.com/grafana/memo/parser"
+	"github.com/grafana/memo/ratelimit"
 	"github.com/grafana/memo/service"
 	"github.com/grafana/memo/store"
 
@@ -29,94 +42,379 @@ type SlackService struct {
 	parser parser.Parser
 	// store puts the memo in the defined store
 	store store.Store
+	// policy decides who may create memos, and where
+	policy *auth.Policy
+	// limiter rate limits memos by user, channel and source
+	limiter *ratelimit.Limiter
+	// dedup suppresses duplicate memos
+	dedup *dedup.Filter
+	// tags provides the channel default tags
+	tags *mem.TagPolicy
+	// pool handles the messages, in order per channel
+	pool *service.Pool
+
+	// markup converts the slack markup in memos
+	markup markup
+
+	// botID is the user ID of the bot, to recognise @-mentions of it. It
+	// is set by Run, while the workers read it
+	botID atomic.Value
 
 	// api client for talking to the slack API
 	api *slack.Client
 	// socket client connected to the slack websocket API
 	socket *socketmode.Client
 
+	// inFlight tracks the memos being handled, to drain them on shutdown
+	inFlight service.InFlight
+
+	// remind posts reminders of future memos if set
+	remind bool
+	// reminders of future memos not posted yet
+	reminders *service.Reminders
+
 	// see https://github.com/nlopes/slack/issues/532
-	// chanIdToNameCache
-	chanIdToNameCache map[string]string
-	// userIdToNameCache
-	userIdToNameCache map[string]string
+	// channels caches channel names by ID
+	channels *cache
+	// users caches slackUsers by ID
+	users *cache
+	// warming is set once the caches are being warmed, so it is done on the
+	// first Run only, not on reconnects. It is cleared again if the Run is
+	// cancelled before the caches are warm
+	warming int32
+
+	// groupsMu protects groups and groupsFetched
+	groupsMu sync.Mutex
+	// groups are the user groups of the workspace, with their members
+	groups []slack.UserGroup
+	// groupsFetched is when groups was last refreshed
+	groupsFetched time.Time
 }
 
+// groupsTTL is how long the user groups are cached
+const groupsTTL = 5 * time.Minute
+
 // Name returns the basic name of this service
-func (s SlackService) Name() string {
+func (s *SlackService) Name() string {
 	return "slack"
 }
 
-// chanIdToName gets and stores the channel name from the id
+// chanIdToName gets and stores the channel name from the id. The id is
+// returned if the name can't be looked up
 func (s *SlackService) chanIdToName(id string) string {
-	name, ok := s.chanIdToNameCache[id]
+	name, ok := s.channels.Get(id)
 	if ok {
-		return name
+		return name.(string)
 	}
 	g, err := s.api.GetConversationInfo(id, false)
 	if err != nil {
 		log.Debugf("GetChannelInfo error: %s", err.Error())
-		return "null"
+		return id
 	}
-	s.chanIdToNameCache[id] = g.Name
+	s.channels.Set(id, g.Name)
 	return g.Name
 }
 
-// userIdToName gets and stores the user name from the id
-func (s *SlackService) userIdToName(id string) string {
-	name, ok := s.userIdToNameCache[id]
+// slackUser is what we need to know about a slack user
+type slackUser struct {
+	// name of the user
+	name string
+	// loc is the time zone of the user, from their profile
+	loc *time.Location
+}
+
+// user gets and stores the user name and time zone from the id. The id is
+// the name if the user can't be looked up
+func (s *SlackService) user(id string) slackUser {
+	cached, ok := s.users.Get(id)
 	if ok {
-		return name
+		return cached.(slackUser)
 	}
 	u, err := s.api.GetUserInfo(id)
 	if err != nil {
 		log.Errorf("GetUserInfo error: %s (You probably don't have the `users:read` scope)", err.Error())
-		return "null"
+		return slackUser{name: id, loc: time.UTC}
 	}
-	s.userIdToNameCache[id] = u.Name
-	return u.Name
+	usr := newSlackUser(u)
+	s.users.Set(id, usr)
+	return usr
+}
+
+// newSlackUser returns what we need to know about u
+func newSlackUser(u *slack.User) slackUser {
+	return slackUser{name: u.Name, loc: location(u)}
+}
+
+// warmCaches fills the channel and user caches with the channels and users
+// of the workspace, so the first memos don't wait on lookups. Failures are
+// logged, the names are then looked up one by one. It returns false if ctx
+// was cancelled before it finished
+func (s *SlackService) warmCaches(ctx context.Context) bool {
+	params := &slack.GetConversationsParameters{
+		ExcludeArchived: true,
+		Limit:           1000,
+		Types:           []string{"public_channel", "private_channel"},
+	}
+	channels := 0
+	for {
+		page, cursor, err := s.api.GetConversationsContext(ctx, params)
+		if err != nil {
+			log.Warnf("could not list slack channels: %s", err)
+			break
+		}
+		for _, c := range page {
+			s.channels.Set(c.ID, c.Name)
+		}
+		channels += len(page)
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+
+	users, err := s.api.GetUsersContext(ctx)
+	if err != nil {
+		log.Warnf("could not list slack users: %s (You probably don't have the `users:read` scope)", err)
+	}
+	for i := range users {
+		s.users.Set(users[i].ID, newSlackUser(&users[i]))
+	}
+
+	if ctx.Err() != nil {
+		return false
+	}
+	log.Debugf("cached the names of %d slack channels and %d users", channels, len(users))
+	return true
+}
+
+// userChange returns the envelope ID of a user_change event and the user
+// that changed. slackevents does not know the event, so the socket sends
+// it as a bad message
+func userChange(message json.RawMessage) (string, string, bool) {
+	var req struct {
+		EnvelopeID string `json:"envelope_id"`
+		Payload    struct {
+			Event struct {
+				Type string `json:"type"`
+				User struct {
+					ID string `json:"id"`
+				} `json:"user"`
+			} `json:"event"`
+		} `json:"payload"`
+	}
+	err := json.Unmarshal(message, &req)
+	if err != nil || req.Payload.Event.Type != "user_change" || req.Payload.Event.User.ID == "" {
+		return "", "", false
+	}
+	return req.EnvelopeID, req.Payload.Event.User.ID, true
+}
+
+// location returns the time zone of the user, or a fixed zone with its
+// offset if the zone is unknown here
+func location(u *slack.User) *time.Location {
+	loc, err := time.LoadLocation(u.TZ)
+	if err != nil || u.TZ == "" {
+		return time.FixedZone(u.TZLabel, u.TZOffset)
+	}
+	return loc
+}
+
+// userGroups returns the IDs and handles of the user groups the user is
+// a member of
+func (s *SlackService) userGroups(id string) []string {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	if time.Since(s.groupsFetched) > groupsTTL {
+		groups, err := s.api.GetUserGroups(slack.GetUserGroupsOptionIncludeUsers(true))
+		if err != nil {
+			log.Errorf("GetUserGroups error: %s (You probably don't have the `usergroups:read` scope)", err.Error())
+		} else {
+			s.groups = groups
+			s.groupsFetched = time.Now()
+		}
+	}
+
+	var names []string
+	for _, g := range s.groups {
+		for _, member := range g.Users {
+			if member == id {
+				names = append(names, g.ID, g.Handle)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// timestamp returns the time of a slack message timestamp such as
+// "1714550400.123456", or the zero time if it can't be parsed
+func timestamp(ts string) time.Time {
+	parts := strings.SplitN(ts, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		log.Debugf("invalid slack message timestamp %q", ts)
+		return time.Time{}
+	}
+	var usec int64
+	if len(parts) == 2 {
+		usec, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	return time.Unix(sec, usec*int64(time.Microsecond))
+}
+
+// permalink returns the link to the message, or an empty string if slack
+// does not give one
+func (s *SlackService) permalink(channel, ts string) string {
+	link, err := s.api.GetPermalink(&slack.PermalinkParameters{Channel: channel, Ts: ts})
+	if err != nil {
+		log.Debugf("GetPermalink error: %s", err.Error())
+		return ""
+	}
+	return link
+}
+
+// mentions returns how the bot can be @-mentioned, once Run knows its ID
+func (s *SlackService) mentions() []string {
+	id, _ := s.botID.Load().(string)
+	if id == "" {
+		return nil
+	}
+	return []string{"<@" + id + ">"}
 }
 
 // handleMessage takes the slack message event and creates the memo, to pass
 // to the store for storing the memo
 func (s *SlackService) handleMessage(msg *slackevents.MessageEvent) error {
 	ch := s.chanIdToName(msg.Channel)
-	usr := s.userIdToName(msg.User)
 
-	memo, err := s.parser.Parse(msg.Text)
+	subject := auth.Subject{
+		Source:      "slack",
+		UserID:      msg.User,
+		ChannelID:   msg.Channel,
+		ChannelName: ch,
+	}
+	if !s.policy.Listens(subject) {
+		return nil
+	}
+
+	usr := s.user(msg.User)
+	subject.UserName = usr.name
+
+	metrics.MessagesReceived.WithLabelValues("slack", ch).Inc()
+
+	memos, err := s.parser.ParseAll(parser.Message{
+		Text:     msg.Text,
+		Time:     timestamp(msg.TimeStamp),
+		Location: usr.loc,
+		Channels: []string{msg.Channel, ch},
+		Mentions: s.mentions(),
+	})
 	if err != nil {
-		s.api.PostMessage(msg.Channel, slack.MsgOptionPostEphemeral(msg.User), slack.MsgOptionText(err.Error(), false))
+		if err == mem.ErrEmpty {
+			return nil
+		}
+		metrics.MemosRejected.WithLabelValues("slack", ch, metrics.ReasonParse).Inc()
+		s.api.PostMessage(msg.Channel, slack.MsgOptionPostEphemeral(msg.User), slack.MsgOptionText(service.Failed(err), false))
 		return err
 	}
 
-	tags := []string{
-		"author:" + usr,
-		"chan:" + ch,
-		"source: slack",
+	if len(memos) == 0 {
+		return nil
 	}
+	metrics.MemosParsed.WithLabelValues("slack", ch).Add(float64(len(memos)))
 
-	memo.BuildTags(tags)
+	if s.policy.NeedsGroups() {
+		subject.Groups = s.userGroups(msg.User)
+	}
+	err = s.policy.Authorize(auth.ActionCreate, subject, "")
+	if err != nil {
+		metrics.MemosRejected.WithLabelValues("slack", ch, metrics.ReasonDenied).Inc()
+		s.api.PostMessage(msg.Channel, slack.MsgOptionPostEphemeral(msg.User), slack.MsgOptionText(err.Error(), false))
+		return err
+	}
 
-	err = s.store.Save(*memo)
+	// socket mode redelivers events when the ack is slow
+	key := dedup.NewKey("slack", msg.TimeStamp, msg.Channel, msg.User, msg.Text)
+	err = s.dedup.Claim(key)
 	if err != nil {
-		s.api.PostMessage(msg.Channel, slack.MsgOptionPostEphemeral(msg.User), slack.MsgOptionText("memo failed: "+err.Error(), false))
+		metrics.MemosRejected.WithLabelValues("slack", ch, metrics.ReasonDuplicate).Inc()
+		if dup, ok := err.(*dedup.DuplicateError); ok && !dup.Redelivery {
+			s.api.PostMessage(msg.Channel, slack.MsgOptionPostEphemeral(msg.User), slack.MsgOptionText(err.Error(), false))
+		}
+		return nil
+	}
+
+	err = s.limiter.Allow("slack", msg.Channel, msg.User)
+	if err != nil {
+		s.dedup.Release(key)
+		metrics.MemosRejected.WithLabelValues("slack", ch, metrics.ReasonRateLimited).Inc()
+		s.api.PostMessage(msg.Channel, slack.MsgOptionPostEphemeral(msg.User), slack.MsgOptionText(err.Error(), false))
 		return err
 	}
 
-	s.api.PostMessage(msg.Channel, slack.MsgOptionPostEphemeral(msg.User), slack.MsgOptionText("Memo saved", false))
+	permalink := s.permalink(msg.Channel, msg.TimeStamp)
+	var replies []string
+	defer func() {
+		s.api.PostMessage(msg.Channel, slack.MsgOptionPostEphemeral(msg.User), slack.MsgOptionText(strings.Join(replies, "\n"), false))
+	}()
+	for i, memo := range memos {
+		memo.Desc = s.markup.Normalize(memo.Desc)
+		memo.Body = s.markup.Normalize(memo.Body)
+		memo.Author = mem.Author{ID: msg.User, Name: usr.name}
+		memo.Channel = mem.Channel{ID: msg.Channel, Name: ch}
+		memo.Source = "slack"
+		memo.MessageID = msg.TimeStamp
+		memo.Permalink = permalink
+		memo.Tags.AddChannel(s.tags.ChannelDefaults(msg.Channel, ch)...)
+
+		err = s.store.Save(s.inFlight.Context(), *memo)
+		if err != nil {
+			// only allow a retry if nothing was saved yet
+			if i == 0 {
+				s.dedup.Release(key)
+			}
+			metrics.MemosRejected.WithLabelValues("slack", ch, metrics.ReasonStore).Add(float64(len(memos) - i))
+			replies = append(replies, service.Unsaved(memos[i:], i, err))
+			return err
+		}
+
+		metrics.MemosSaved.WithLabelValues("slack", ch).Inc()
+		replies = append(replies, service.Saved(memo))
+
+		if s.remind && memo.Date.After(time.Now()) {
+			s.reminders.Schedule("slack", msg.Channel, *memo)
+		}
+	}
 	return nil
 }
 
 // New creates a new instance of this service
-func New(config cfg.Slack, parser parser.Parser, store store.Store) (service.Service, error) {
-	s := SlackService{
+func New(config cfg.Slack, deps service.Deps) (service.Service, error) {
+	s := &SlackService{
 		botToken: config.BotToken,
 		appToken: config.AppToken,
+		remind:   config.Reminders,
+
+		parser:  deps.Parser,
+		store:   deps.Store,
+		policy:  deps.Policy,
+		limiter: deps.Limiter,
+		dedup:   deps.Dedup,
+		tags:    deps.Tags,
+		pool:    deps.Pool,
 
-		parser: parser,
+		reminders: deps.Reminders,
 
-		chanIdToNameCache: make(map[string]string),
-		userIdToNameCache: make(map[string]string),
+		inFlight: service.InFlight{Source: "slack"},
+
+		channels: newCache(namesTTL),
+		users:    newCache(namesTTL),
+	}
+
+	s.markup = markup{
+		user:    func(id string) string { return s.user(id).name },
+		channel: s.chanIdToName,
 	}
 
 	s.api = slack.New(
@@ -130,48 +428,164 @@ func New(config cfg.Slack, parser parser.Parser, store store.Store) (service.Ser
 		socketmode.OptionLog(llog.New(os.Stdout, "slack", llog.Lshortfile|llog.LstdFlags)),
 	)
 
-	go func() {
-		for evt := range s.socket.Events {
-			switch evt.Type {
-			case socketmode.EventTypeConnecting:
-				log.Info("Connecting to slack")
-			case socketmode.EventTypeConnectionError:
-				log.Errorf("Connection error: %v", evt)
-			case socketmode.EventTypeConnected:
-				log.Info("Socket connected")
-			case socketmode.EventTypeDisconnect:
-				log.Info("Socket disconnected")
-			case socketmode.EventTypeIncomingError:
-				log.Errorf("Connection error: %v", evt)
-			case socketmode.EventTypeHello:
-				log.Info("Received hello from slack, hi!")
-			case socketmode.EventTypeEventsAPI:
-				eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
-				if !ok {
-					fmt.Printf("Ignored %+v\n", evt)
-
-					continue
-				}
-
-				s.socket.Ack(*evt.Request)
-				switch eventsAPIEvent.Type {
-				case slackevents.CallbackEvent:
-					innerEvent := eventsAPIEvent.InnerEvent
-					switch ev := innerEvent.Data.(type) {
-					case *slackevents.MessageEvent:
-						s.handleMessage(ev)
-					}
-				}
-			default:
-				fmt.Fprintf(os.Stderr, "Unexpected event type received: %s\n", evt.Type)
+	return s, nil
+}
+
+// Check verifies the bot and app tokens of config with the slack API
+func Check(ctx context.Context, config cfg.Slack) error {
+	api := slack.New(
+		config.BotToken,
+		slack.OptionAppLevelToken(config.AppToken),
+	)
+
+	auth, err := api.AuthTestContext(ctx)
+	if err != nil {
+		return fmt.Errorf("bot token rejected: %s", err)
+	}
+	log.Debugf("slack bot token belongs to %s in %s", auth.User, auth.Team)
+
+	_, _, err = api.StartSocketModeContext(ctx)
+	if err != nil {
+		return fmt.Errorf("app token rejected, or socket mode is not enabled: %s", err)
+	}
+
+	return nil
+}
+
+// Run connects the socket and handles the incoming events until the
+// connection fails or ctx is cancelled
+func (s *SlackService) Run(ctx context.Context, status *service.Status) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	auth, err := s.api.AuthTestContext(ctx)
+	if err != nil {
+		return fmt.Errorf("slack auth test failed: %s", err)
+	}
+	s.botID.Store(auth.UserID)
+
+	if s.remind {
+		s.reminders.Register("slack", s)
+	}
+
+	if atomic.CompareAndSwapInt32(&s.warming, 0, 1) {
+		go func() {
+			if !s.warmCaches(ctx) {
+				atomic.StoreInt32(&s.warming, 0)
 			}
-		}
-	}()
+		}()
+	}
 
+	errc := make(chan error, 1)
 	go func() {
-		err := s.socket.Run()
-		log.Fatalf("slack socket closed: %s", err.Error())
+		errc <- s.socket.RunContext(ctx)
 	}()
 
-	return s, nil
+	// keep consuming events until the socket returns, so it never blocks
+	// on sending one
+	for {
+		select {
+		case err := <-errc:
+			if err == nil {
+				err = errors.New("slack socket closed")
+			}
+			return err
+		case evt := <-s.socket.Events:
+			s.handleEvent(evt, status)
+		}
+	}
+}
+
+// handleEvent handles a single event received from the socket
+func (s *SlackService) handleEvent(evt socketmode.Event, status *service.Status) {
+	switch evt.Type {
+	case socketmode.EventTypeConnecting:
+		log.Info("Connecting to slack")
+		status.Set(service.StateConnecting)
+	case socketmode.EventTypeConnectionError:
+		log.Errorf("Connection error: %v", evt)
+	case socketmode.EventTypeConnected:
+		log.Info("Socket connected")
+		status.Set(service.StateConnected)
+	case socketmode.EventTypeDisconnect:
+		log.Info("Socket disconnected")
+		status.Set(service.StateConnecting)
+	case socketmode.EventTypeIncomingError:
+		log.Errorf("Connection error: %v", evt)
+	case socketmode.EventTypeErrorBadMessage:
+		bad, ok := evt.Data.(*socketmode.ErrorBadMessage)
+		if !ok {
+			return
+		}
+		if envelope, user, ok := userChange(bad.Message); ok {
+			s.socket.Ack(socketmode.Request{EnvelopeID: envelope})
+			s.users.Forget(user)
+			return
+		}
+		log.Errorf("Bad message from slack: %s", bad.Cause)
+	case socketmode.EventTypeHello:
+		log.Info("Received hello from slack, hi!")
+	case socketmode.EventTypeEventsAPI:
+		eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
+		if !ok {
+			fmt.Printf("Ignored %+v\n", evt)
+
+			return
+		}
+
+		// once we are shutting down, leave the event unacknowledged
+		// so slack redelivers it
+		if !s.inFlight.Begin() {
+			return
+		}
+
+		ev, ok := eventsAPIEvent.InnerEvent.Data.(*slackevents.MessageEvent)
+		if eventsAPIEvent.Type != slackevents.CallbackEvent || !ok {
+			s.socket.Ack(*evt.Request)
+			s.handleOther(eventsAPIEvent)
+			s.inFlight.Done()
+			return
+		}
+
+		// the messages of a channel are handled in order by the pool. if
+		// it is busy, leave the event unacknowledged so slack redelivers
+		// it later. waiting for room would hold up all channels, and
+		// miss the ack deadline of 3s
+		err := s.pool.TrySubmit("slack", ev.Channel, func() {
+			defer s.inFlight.Done()
+			s.handleMessage(ev)
+		})
+		if err != nil {
+			s.inFlight.Done()
+			log.Warnf("dropping slack message %s in %s: %s", ev.TimeStamp, ev.Channel, err)
+			return
+		}
+		s.socket.Ack(*evt.Request)
+	default:
+		fmt.Fprintf(os.Stderr, "Unexpected event type received: %s\n", evt.Type)
+	}
+}
+
+// handleOther handles the events that are not messages
+func (s *SlackService) handleOther(event slackevents.EventsAPIEvent) {
+	if event.Type != slackevents.CallbackEvent {
+		return
+	}
+	switch ev := event.InnerEvent.Data.(type) {
+	case *slackevents.ChannelRenameEvent:
+		s.channels.Forget(ev.Channel.ID)
+	}
+}
+
+// Drain stops acknowledging events and waits for the memos being handled.
+// Reminders not posted yet are kept, for the service that replaces this one
+func (s *SlackService) Drain(ctx context.Context) error {
+	s.reminders.Unregister("slack", s)
+	return s.inFlight.Drain(ctx)
+}
+
+// Remind posts the reminder of memo to channel
+func (s *SlackService) Remind(channel string, memo mem.Memo) error {
+	_, _, err := s.api.PostMessage(channel, slack.MsgOptionText(service.Reminder(&memo), false))
+	return err
 }
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// minBackoff is the delay before the first restart of a failed service
+	minBackoff = time.Second
+	// maxBackoff caps the delay between restarts
+	maxBackoff = 2 * time.Minute
+)
+
+// State of the connection of a service
+type State int32
+
+const (
+	StateConnecting State = iota
+	StateConnected
+	StateFailed
+	StateStopped
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateFailed:
+		return "failed"
+	case StateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// Status holds the connection state of a service, safe for concurrent use
+type Status struct {
+	state int32
+}
+
+// Set updates the state
+func (s *Status) Set(state State) {
+	atomic.StoreInt32(&s.state, int32(state))
+}
+
+// Get returns the current state
+func (s *Status) Get() State {
+	return State(atomic.LoadInt32(&s.state))
+}
+
+// Supervisor runs a service and restarts it with exponential backoff when
+// its connection fails, so one flaky integration does not take down the
+// others
+type Supervisor struct {
+	// svc is the supervised service
+	svc Service
+	// status of the service connection
+	status Status
+	// reconnects counts the restarts after a failure
+	reconnects int64
+
+	// cancel stops the run loop
+	cancel context.CancelFunc
+	// done is closed once the run loop has returned
+	done chan struct{}
+}
+
+// Supervise starts running svc in the background
+func Supervise(svc Service) *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Supervisor{
+		svc:    svc,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go s.run(ctx)
+
+	return s
+}
+
+// Name returns the name of the supervised service
+func (s *Supervisor) Name() string {
+	return s.svc.Name()
+}
+
+// State returns the connection state of the supervised service
+func (s *Supervisor) State() State {
+	return s.status.Get()
+}
+
+// Reconnects returns how often the service was restarted after a failure
+func (s *Supervisor) Reconnects() int64 {
+	return atomic.LoadInt64(&s.reconnects)
+}
+
+// run keeps the service running until ctx is cancelled
+func (s *Supervisor) run(ctx context.Context) {
+	defer close(s.done)
+
+	backoff := minBackoff
+	for {
+		s.status.Set(StateConnecting)
+		started := time.Now()
+
+		err := s.svc.Run(ctx, &s.status)
+		if ctx.Err() != nil {
+			s.status.Set(StateStopped)
+			return
+		}
+
+		s.status.Set(StateFailed)
+
+		// a connection that stayed up for a while resets the backoff
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		log.Errorf("%s service failed: %v. restarting in %s", s.svc.Name(), err, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			s.status.Set(StateStopped)
+			return
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		atomic.AddInt64(&s.reconnects, 1)
+	}
+}
+
+// Stop drains the in-flight memos of the service, then closes its
+// connection
+func (s *Supervisor) Stop(ctx context.Context) error {
+	err := s.svc.Drain(ctx)
+
+	s.cancel()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("connection did not close: %s", ctx.Err())
+		}
+	}
+
+	return err
+}
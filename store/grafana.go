@@ -2,6 +2,7 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -9,8 +10,11 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"text/template"
+	"time"
 
 	"github.com/grafana/memo"
+	"github.com/grafana/memo/cfg"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -26,6 +30,8 @@ type Grafana struct {
 	tlsKey string
 	// tlsCert
 	tlsCert string
+	// timeout of each request
+	timeout time.Duration
 
 	// bearerHeader is an internal cache of the header with the apiKey present
 	bearerHeader string
@@ -33,15 +39,71 @@ type Grafana struct {
 	apiUrlAnnotations string
 	// apiUrlHealth is an internal cache of the url for the API health page
 	apiUrlHealth string
+
+	// metadata is how author, channel and source are rendered
+	metadata string
+	// text renders the annotation text from the memo
+	text *template.Template
 }
 
+// How the metadata of a memo is rendered in the annotation
+const (
+	// MetadataTags renders author, channel and source as tags
+	MetadataTags = "tags"
+	// MetadataFooter renders them as a footer line of the text
+	MetadataFooter = "footer"
+	// MetadataBoth renders them as tags and in the footer
+	MetadataBoth = "both"
+)
+
+// DefaultTimeout is how long a request to Grafana may take when no timeout
+// is configured
+const DefaultTimeout = 10 * time.Second
+
+// defaultTextTemplate renders the annotation text, with a link back to the
+// chat message if there is one
+const defaultTextTemplate = `{{.Desc}}{{with .Body}}
+
+{{.}}{{end}}{{if .Permalink}}
+
+[view message]({{.Permalink}}){{end}}`
+
+// defaultFooterTemplate renders the annotation text with a footer line
+// naming the author, channel and source, if any are known
+const defaultFooterTemplate = `{{.Desc}}{{with .Body}}
+
+{{.}}{{end}}{{if or .Author.DisplayName .Channel.DisplayName .Source .Permalink}}
+
+—{{with .Author.DisplayName}} {{.}}{{end}}{{with .Channel.DisplayName}} in #{{.}}{{end}}{{with .Source}} via {{.}}{{end}}{{if .Permalink}} ([view message]({{.Permalink}})){{end}}{{end}}`
+
 // NewGrafana returns a new grafana instance
-func NewGrafana(apiKey, apiUrl, tlsKey, tlsCert string) (Grafana, error) {
-	u, err := url.Parse(apiUrl)
+func NewGrafana(config cfg.Grafana) (Grafana, error) {
+	u, err := url.Parse(config.ApiUrl)
 	if err != nil {
 		return Grafana{}, err
 	}
 
+	metadata := config.Metadata
+	switch metadata {
+	case "":
+		metadata = MetadataTags
+	case MetadataTags, MetadataFooter, MetadataBoth:
+	default:
+		return Grafana{}, fmt.Errorf("unknown grafana metadata rendering %q", metadata)
+	}
+
+	text := config.TextTemplate
+	if text == "" {
+		text = defaultTextTemplate
+		if metadata == MetadataFooter || metadata == MetadataBoth {
+			text = defaultFooterTemplate
+		}
+	}
+	tmpl, err := template.New("text").Parse(text)
+	if err != nil {
+		return Grafana{}, fmt.Errorf("invalid grafana text_template: %s", err)
+	}
+
 	urlAnnotations := *u
 	urlAnnotations.Path = path.Join(u.Path, "annotations")
 
@@ -49,14 +111,18 @@ func NewGrafana(apiKey, apiUrl, tlsKey, tlsCert string) (Grafana, error) {
 	urlHealth.Path = path.Join(u.Path, "health")
 
 	g := Grafana{
-		apiKey:  apiKey,
-		apiUrl:  apiUrl,
-		tlsKey:  tlsKey,
-		tlsCert: tlsCert,
+		apiKey:  config.ApiKey,
+		apiUrl:  config.ApiUrl,
+		tlsKey:  config.TLSKey,
+		tlsCert: config.TLSCert,
+		timeout: config.Timeout.Or(DefaultTimeout),
 
-		bearerHeader:      fmt.Sprintf("Bearer %s", apiKey),
+		bearerHeader:      fmt.Sprintf("Bearer %s", config.ApiKey),
 		apiUrlAnnotations: urlAnnotations.String(),
 		apiUrlHealth:      urlHealth.String(),
+
+		metadata: metadata,
+		text:     tmpl,
 	}
 	return g, nil
 }
@@ -73,7 +139,7 @@ type GrafanaHealthResp struct {
 
 // httpClient returns the client for communicating with Grafana
 func (g Grafana) httpClient() (*http.Client, error) {
-	client := &http.Client{}
+	client := &http.Client{Timeout: g.timeout}
 
 	if g.tlsKey != "" || g.tlsCert != "" {
 		// Load client cert
@@ -90,8 +156,21 @@ func (g Grafana) httpClient() (*http.Client, error) {
 	return client, nil
 }
 
+// authorize adds the api key to req, if there is one. Without it, the
+// client certificate or a proxy in front of Grafana authenticates
+func (g Grafana) authorize(req *http.Request) {
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", g.bearerHeader)
+	}
+}
+
 // Check ensures the API is healthy
 func (g Grafana) Check() error {
+	return g.CheckContext(context.Background())
+}
+
+// CheckContext ensures the API is healthy, giving up when ctx expires
+func (g Grafana) CheckContext(ctx context.Context) error {
 	client, err := g.httpClient()
 	if err != nil {
 		return err
@@ -101,8 +180,9 @@ func (g Grafana) Check() error {
 	if err != nil {
 		return fmt.Errorf("grafana creation of request failed: %s", err)
 	}
+	req = req.WithContext(ctx)
 
-	req.Header.Set("Authorization", g.bearerHeader)
+	g.authorize(req)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -132,12 +212,18 @@ func (g Grafana) Check() error {
 type GrafanaAnnotationReq struct {
 	// Time unix ts in ms
 	Time int64 `json:"time"`
+	// TimeEnd unix ts in ms, for regions
+	TimeEnd int64 `json:"timeEnd,omitempty"`
 	// IsRegion
 	IsRegion bool `json:"isRegion"`
 	// Tags
 	Tags []string `json:"tags"`
 	// Text
 	Text string `json:"text"`
+	// DashboardUID limits the annotation to a dashboard
+	DashboardUID string `json:"dashboardUID,omitempty"`
+	// PanelID limits the annotation to a panel
+	PanelID int64 `json:"panelId,omitempty"`
 }
 
 // GrafanaAnnotationResp
@@ -150,13 +236,91 @@ type GrafanaAnnotationResp struct {
 	EndId int `json:"endId"`
 }
 
-// Save stores the memo in the API
-func (g Grafana) Save(memo memo.Memo) error {
+// annotation renders the memo as a grafana annotation. The time range,
+// dashboard and panel map to native fields, the text is rendered by the
+// text template, author, channel and source are rendered as tags
+// according to the metadata setting
+func (g Grafana) annotation(memo memo.Memo) (GrafanaAnnotationReq, error) {
+	var text bytes.Buffer
+	err := g.text.Execute(&text, memo)
+	if err != nil {
+		return GrafanaAnnotationReq{}, fmt.Errorf("rendering the text of the memo failed: %s", err)
+	}
+
 	ga := GrafanaAnnotationReq{
-		Time:     memo.Date.Unix() * 1000,
-		IsRegion: false,
-		Tags:     memo.Tags,
-		Text:     memo.Desc,
+		Time:         memo.Date.Unix() * 1000,
+		IsRegion:     false,
+		Text:         text.String(),
+		DashboardUID: memo.DashboardUID,
+		PanelID:      memo.PanelID,
+	}
+
+	if !memo.End.IsZero() && memo.End.After(memo.Date) {
+		ga.TimeEnd = memo.End.Unix() * 1000
+		ga.IsRegion = true
+	}
+
+	if g.metadata == MetadataTags || g.metadata == MetadataBoth {
+		if name := memo.Author.DisplayName(); name != "" {
+			memo.Tags.AddSystem("author", name)
+		}
+		if name := memo.Channel.DisplayName(); name != "" {
+			memo.Tags.AddSystem("chan", name)
+		}
+		if memo.Source != "" {
+			memo.Tags.AddSystem("source", memo.Source)
+		}
+	}
+
+	ga.Tags = memo.Tags.Strings()
+	return ga, nil
+}
+
+// Tagged returns whether an annotation with the tag exists
+func (g Grafana) Tagged(tag string) (bool, error) {
+	client, err := g.httpClient()
+	if err != nil {
+		return false, err
+	}
+
+	query := url.Values{}
+	query.Set("tags", tag)
+	query.Set("limit", "1")
+	req, err := http.NewRequest("GET", g.apiUrlAnnotations+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, fmt.Errorf("grafana creation of request failed: %s", err)
+	}
+
+	g.authorize(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("grafana query fail: %s", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("grafana failed to read body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Grafana replied with http %d and body %s", resp.StatusCode, string(data))
+	}
+
+	var annotations []json.RawMessage
+	err = json.Unmarshal(data, &annotations)
+	if err != nil {
+		return false, fmt.Errorf("grafana failed to unmarshal grafana response: %s. The body was: %s", err, string(data))
+	}
+	return len(annotations) > 0, nil
+}
+
+// Save stores the memo in the API, giving up when ctx expires
+func (g Grafana) Save(ctx context.Context, memo memo.Memo) error {
+	ga, err := g.annotation(memo)
+	if err != nil {
+		return err
 	}
 	jsonValue, _ := json.Marshal(ga)
 
@@ -169,9 +333,10 @@ func (g Grafana) Save(memo memo.Memo) error {
 	if err != nil {
 		return fmt.Errorf("grafana creation of request failed: %s", err)
 	}
+	req = req.WithContext(ctx)
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", g.bearerHeader)
+	g.authorize(req)
 
 	resp, err := client.Do(req)
 	if err != nil {
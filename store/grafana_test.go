@@ -0,0 +1,112 @@
+package store
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/memo"
+	"github.com/grafana/memo/cfg"
+)
+
+func TestAnnotation(t *testing.T) {
+	m := memo.Memo{
+		Date:    time.Unix(100, 0),
+		End:     time.Unix(160, 0),
+		Desc:    "restarted db",
+		Author:  memo.Author{ID: "U1", Name: "alice"},
+		Channel: memo.Channel{ID: "C1", Name: "ops"},
+		Source:  "slack",
+
+		DashboardUID: "abc",
+		PanelID:      2,
+	}
+	m.Tags.AddUser(memo.Tag{Key: "env", Value: "prod"})
+
+	withLink := m
+	withLink.Permalink = "https://example.slack.com/archives/C1/p100"
+
+	withBody := m
+	withBody.Body = "* stopped writes\n* restarted"
+
+	// like from memo-cli
+	anonymous := m
+	anonymous.Author = memo.Author{}
+	anonymous.Channel = memo.Channel{}
+	anonymous.Source = ""
+	anonymous.Tags = memo.TagSet{}
+
+	cases := []struct {
+		metadata string
+		template string
+		memo     memo.Memo
+		expTags  []string
+		expText  string
+	}{
+		{"", "", m, []string{"author:alice", "chan:ops", "env:prod", "memo", "source:slack"}, "restarted db"},
+		{"", "", withLink, []string{"author:alice", "chan:ops", "env:prod", "memo", "source:slack"}, "restarted db\n\n[view message](https://example.slack.com/archives/C1/p100)"},
+		{"footer", "", m, []string{"env:prod", "memo"}, "restarted db\n\n— alice in #ops via slack"},
+		{"footer", "", withLink, []string{"env:prod", "memo"}, "restarted db\n\n— alice in #ops via slack ([view message](https://example.slack.com/archives/C1/p100))"},
+		{"", "", withBody, []string{"author:alice", "chan:ops", "env:prod", "memo", "source:slack"}, "restarted db\n\n* stopped writes\n* restarted"},
+		{"footer", "", withBody, []string{"env:prod", "memo"}, "restarted db\n\n* stopped writes\n* restarted\n\n— alice in #ops via slack"},
+		{"footer", "", anonymous, []string{"memo"}, "restarted db"},
+		{"both", "", m, []string{"author:alice", "chan:ops", "env:prod", "memo", "source:slack"}, "restarted db\n\n— alice in #ops via slack"},
+		{"footer", "{{.Author.Name}}: {{.Desc}} {{.Permalink}}", withLink, []string{"env:prod", "memo"}, "alice: restarted db https://example.slack.com/archives/C1/p100"},
+	}
+
+	for _, c := range cases {
+		g, err := NewGrafana(cfg.Grafana{ApiUrl: "http://localhost/api/", Metadata: c.metadata, TextTemplate: c.template})
+		if err != nil {
+			t.Fatalf("%q: unexpected error %s", c.metadata, err)
+		}
+
+		ga, err := g.annotation(c.memo)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %s", c.metadata, err)
+		}
+		if !reflect.DeepEqual(ga.Tags, c.expTags) || ga.Text != c.expText {
+			t.Errorf("%q: exp tags=%v text=%q, got tags=%v text=%q", c.metadata, c.expTags, c.expText, ga.Tags, ga.Text)
+		}
+		if ga.Time != 100000 || ga.TimeEnd != 160000 || !ga.IsRegion || ga.DashboardUID != "abc" || ga.PanelID != 2 {
+			t.Errorf("%q: native fields not set: %+v", c.metadata, ga)
+		}
+	}
+
+	_, err := NewGrafana(cfg.Grafana{ApiUrl: "http://localhost/api/", TextTemplate: "{{.Desc"})
+	if err == nil {
+		t.Errorf("expected an error for an invalid text_template")
+	}
+
+	// the memo itself is not changed
+	if !reflect.DeepEqual(m.Tags.Strings(), []string{"env:prod", "memo"}) {
+		t.Errorf("memo tags were modified: %v", m.Tags.Strings())
+	}
+}
+
+func TestTagged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/api/annotations" || r.URL.Query().Get("limit") != "1" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if r.URL.Query().Get("tags") == "dedup:job-1" {
+			w.Write([]byte(`[{"id": 1, "tags": ["dedup:job-1"]}]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	g, err := NewGrafana(cfg.Grafana{ApiUrl: server.URL + "/api/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tag, exp := range map[string]bool{"dedup:job-1": true, "dedup:job-2": false} {
+		got, err := g.Tagged(tag)
+		if err != nil || got != exp {
+			t.Errorf("%s: exp %t, got %t, %v", tag, exp, got, err)
+		}
+	}
+}
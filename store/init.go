@@ -1,9 +1,16 @@
 package store
 
-import "github.com/grafana/memo"
+import (
+	"context"
+
+	"github.com/grafana/memo"
+)
 
 // Store
 type Store interface {
-	// Save stores the memo in the storage engine
-	Save(memo memo.Memo) error
+	// Save stores the memo in the storage engine, giving up when ctx
+	// expires
+	Save(ctx context.Context, memo memo.Memo) error
+	// Check ensures the storage engine is healthy
+	Check() error
 }
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/memo"
+	"github.com/grafana/memo/metrics"
+)
+
+// Instrumented wraps a store to record the latency of its operations
+type Instrumented struct {
+	// store being instrumented
+	store Store
+	// name used as store label
+	name string
+}
+
+// NewInstrumented returns store wrapped with latency metrics
+func NewInstrumented(store Store, name string) Instrumented {
+	return Instrumented{
+		store: store,
+		name:  name,
+	}
+}
+
+// Save stores the memo in the wrapped store
+func (i Instrumented) Save(ctx context.Context, memo memo.Memo) error {
+	start := time.Now()
+	err := i.store.Save(ctx, memo)
+	metrics.StoreDuration.WithLabelValues(i.name, "save", metrics.Result(err)).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// Check checks the health of the wrapped store
+func (i Instrumented) Check() error {
+	start := time.Now()
+	err := i.store.Check()
+	metrics.StoreDuration.WithLabelValues(i.name, "check", metrics.Result(err)).Observe(time.Since(start).Seconds())
+	return err
+}
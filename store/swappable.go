@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grafana/memo"
+)
+
+// Swappable is a store whose backend can be replaced while it is in use,
+// e.g. after a config reload
+type Swappable struct {
+	mu    sync.RWMutex
+	store Store
+}
+
+// NewSwappable returns a swappable store backed by store
+func NewSwappable(store Store) *Swappable {
+	return &Swappable{
+		store: store,
+	}
+}
+
+// Swap replaces the backend store
+func (s *Swappable) Swap(store Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.store = store
+}
+
+// current returns the backend store
+func (s *Swappable) current() Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.store
+}
+
+// Save stores the memo in the backend store
+func (s *Swappable) Save(ctx context.Context, memo memo.Memo) error {
+	return s.current().Save(ctx, memo)
+}
+
+// Check checks the health of the backend store
+func (s *Swappable) Check() error {
+	return s.current().Check()
+}
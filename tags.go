@@ -0,0 +1,192 @@
+package memo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/grafana/memo/cfg"
+)
+
+// DefaultReservedKeys are the tag keys set by memo itself, which users
+// cannot override
+var DefaultReservedKeys = []string{"memo", "author", "chan", "guild", "source", "user", "host", "dedup"}
+
+// TagError is returned for a user tag that violates the tag policy
+type TagError struct {
+	// Tag is the offending tag
+	Tag string
+	// Index of the offending tag in the tags given to Clean
+	Index int
+	// Reason explains what is wrong with it
+	Reason string
+}
+
+// Error implements error
+func (e *TagError) Error() string {
+	return fmt.Sprintf("invalid tag %q: %s", e.Tag, e.Reason)
+}
+
+// tagRules are the compiled rules of a cfg.Tags
+type tagRules struct {
+	reserved    map[string]bool
+	normalize   bool
+	allowedKeys map[string]bool
+	keyPattern  *regexp.Regexp
+	maxTags     int
+	maxLength   int
+	// rich is set for the rich tag syntax
+	rich bool
+	// channelDefaults maps lowercased channel names and IDs to their tags
+	channelDefaults map[string][]Tag
+}
+
+// TagPolicy validates and normalizes the tags given by users. It is safe
+// for concurrent use and can be updated on config reload
+type TagPolicy struct {
+	mu    sync.RWMutex
+	rules tagRules
+}
+
+// NewTagPolicy returns a tag policy for config
+func NewTagPolicy(config cfg.Tags) (*TagPolicy, error) {
+	p := &TagPolicy{}
+	err := p.Update(config)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DefaultTagPolicy returns the policy used when none is configured: it
+// only protects the reserved keys and removes duplicates
+func DefaultTagPolicy() *TagPolicy {
+	p, _ := NewTagPolicy(cfg.Tags{})
+	return p
+}
+
+// Update replaces the rules of the policy with config. The policy is left
+// unchanged if config is invalid
+func (p *TagPolicy) Update(config cfg.Tags) error {
+	r := tagRules{
+		reserved:  map[string]bool{},
+		normalize: config.Normalize,
+		maxTags:   config.MaxTags,
+		maxLength: config.MaxLength,
+		rich:      config.Syntax == "rich",
+
+		channelDefaults: map[string][]Tag{},
+	}
+
+	for ch, tags := range config.ChannelDefaults {
+		r.channelDefaults[strings.ToLower(strings.TrimPrefix(ch, "#"))] = ParseTags(tags)
+	}
+
+	reserved := config.Reserved
+	if reserved == nil {
+		reserved = DefaultReservedKeys
+	}
+	for _, k := range reserved {
+		r.reserved[strings.ToLower(k)] = true
+	}
+
+	if len(config.AllowedKeys) > 0 {
+		r.allowedKeys = map[string]bool{}
+		for _, k := range config.AllowedKeys {
+			r.allowedKeys[strings.ToLower(k)] = true
+		}
+	}
+
+	switch config.Syntax {
+	case "", "classic", "rich":
+	default:
+		return fmt.Errorf("invalid tags.syntax %q, must be classic or rich", config.Syntax)
+	}
+
+	if config.KeyPattern != "" {
+		re, err := regexp.Compile("^(?:" + config.KeyPattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid tags.key_pattern %q: %s", config.KeyPattern, err)
+		}
+		r.keyPattern = re
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rules = r
+	return nil
+}
+
+// get returns the current rules
+func (p *TagPolicy) get() tagRules {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.rules
+}
+
+// RichSyntax returns whether users write tags in the rich syntax, with
+// hashtags and quoted values anywhere in the memo
+func (p *TagPolicy) RichSyntax() bool {
+	return p.get().rich
+}
+
+// ChannelDefaults returns the default tags of the channel, looked up by
+// any of its names or IDs
+func (p *TagPolicy) ChannelDefaults(channel ...string) []Tag {
+	r := p.get()
+
+	for _, ch := range channel {
+		if tags, ok := r.channelDefaults[strings.ToLower(ch)]; ok {
+			return tags
+		}
+	}
+	return nil
+}
+
+// Clean normalizes, validates and dedupes the tags a user specified. It
+// returns a *TagError for the first tag that violates the policy
+func (p *TagPolicy) Clean(tags []string) ([]Tag, error) {
+	r := p.get()
+
+	seen := map[Tag]bool{}
+	var out []Tag
+	// indexes of the tags of out in tags
+	var indexes []int
+	for i, s := range tags {
+		if r.normalize {
+			s = strings.ToLower(s)
+		}
+		t := ParseTag(s)
+		if t.Key == "" || seen[t] {
+			continue
+		}
+
+		tag := t.String()
+		key := t.Key
+		switch {
+		// bare labels such as #memo don't override anything. those named
+		// like a system tag are dropped when the tags are merged
+		case t.Value != "" && r.reserved[strings.ToLower(key)]:
+			return nil, &TagError{Tag: tag, Index: i, Reason: fmt.Sprintf("%q is set by memo and cannot be overridden", key)}
+		case r.allowedKeys != nil && !r.allowedKeys[strings.ToLower(key)]:
+			return nil, &TagError{Tag: tag, Index: i, Reason: fmt.Sprintf("%q is not an allowed tag key", key)}
+		case r.keyPattern != nil && !r.keyPattern.MatchString(key):
+			return nil, &TagError{Tag: tag, Index: i, Reason: fmt.Sprintf("%q does not match the tag key pattern", key)}
+		case r.maxLength > 0 && len(tag) > r.maxLength:
+			return nil, &TagError{Tag: tag, Index: i, Reason: fmt.Sprintf("longer than %d characters", r.maxLength)}
+		}
+
+		seen[t] = true
+		out = append(out, t)
+		indexes = append(indexes, i)
+	}
+
+	if r.maxTags > 0 && len(out) > r.maxTags {
+		return nil, &TagError{Tag: out[r.maxTags].String(), Index: indexes[r.maxTags], Reason: fmt.Sprintf("at most %d tags are allowed", r.maxTags)}
+	}
+
+	return out, nil
+}
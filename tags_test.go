@@ -0,0 +1,73 @@
+package memo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/memo/cfg"
+)
+
+func TestTagPolicyClean(t *testing.T) {
+	cases := []struct {
+		config cfg.Tags
+		tags   []string
+		exp    []string
+		expErr bool
+	}{
+		// reserved keys cannot be overridden
+		{tags: []string{"author:someoneelse"}, expErr: true},
+		{tags: []string{"source:cli"}, expErr: true},
+		{config: cfg.Tags{Reserved: []string{}}, tags: []string{"source:cli"}, exp: []string{"source:cli"}},
+		// bare labels named like a reserved key are fine
+		{tags: []string{"memo", "host"}, exp: []string{"memo", "host"}},
+		// dedupe, with and without normalization
+		{tags: []string{"env:prod", " env:prod", "Env:Prod"}, exp: []string{"env:prod", "Env:Prod"}},
+		{config: cfg.Tags{Normalize: true}, tags: []string{"env:prod", "Env : Prod "}, exp: []string{"env:prod"}},
+		// key allowlist and pattern
+		{config: cfg.Tags{AllowedKeys: []string{"env", "team"}}, tags: []string{"team:db"}, exp: []string{"team:db"}},
+		{config: cfg.Tags{AllowedKeys: []string{"env", "team"}}, tags: []string{"region:eu"}, expErr: true},
+		{config: cfg.Tags{KeyPattern: "[a-z]+"}, tags: []string{"k8s:yes"}, expErr: true},
+		// limits
+		{config: cfg.Tags{MaxTags: 1}, tags: []string{"a:1", "b:2"}, expErr: true},
+		{config: cfg.Tags{MaxLength: 5}, tags: []string{"a:123456"}, expErr: true},
+	}
+
+	for i, c := range cases {
+		policy, err := NewTagPolicy(c.config)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %s", i, err)
+		}
+
+		cleaned, err := policy.Clean(c.tags)
+		if c.expErr {
+			if _, ok := err.(*TagError); !ok {
+				t.Errorf("case %d: expected a *TagError for %v, got %v", i, c.tags, err)
+			}
+			continue
+		}
+		var tags []string
+		for _, tag := range cleaned {
+			tags = append(tags, tag.String())
+		}
+		if err != nil || !reflect.DeepEqual(tags, c.exp) {
+			t.Errorf("case %d: exp %v, got %v (err %v)", i, c.exp, tags, err)
+		}
+	}
+}
+
+func TestTagSetPrecedence(t *testing.T) {
+	var s TagSet
+	s.AddUser(ParseTags([]string{"team:db", "env:prod", "deploy", "author:eve"})...)
+	s.AddChannel(ParseTags([]string{"team:ops", "team:sre"})...)
+	s.AddSystem("author", "alice")
+	s.AddSystem("source", "slack")
+
+	exp := []string{"author:alice", "deploy", "env:prod", "memo", "source:slack", "team:ops", "team:sre"}
+	if got := s.Strings(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("exp %v, got %v", exp, got)
+	}
+
+	if v, ok := s.Get("author"); !ok || v != "alice" {
+		t.Errorf("exp author alice, got %q", v)
+	}
+}
@@ -0,0 +1,120 @@
+package memo
+
+import (
+	"sort"
+	"strings"
+)
+
+// BaseTag is the label every memo is tagged with
+const BaseTag = "memo"
+
+// Tag is a `key:value` tag, or a bare label if Value is empty
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// ParseTag parses the canonical encoding of a tag
+func ParseTag(s string) Tag {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, ":"); i >= 0 {
+		return Tag{Key: strings.TrimSpace(s[:i]), Value: strings.TrimSpace(s[i+1:])}
+	}
+	return Tag{Key: s}
+}
+
+// ParseTags parses the canonical encodings of tags
+func ParseTags(tags []string) []Tag {
+	var out []Tag
+	for _, s := range tags {
+		if t := ParseTag(s); t.Key != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// String returns the canonical encoding of the tag, as stored in Grafana
+func (t Tag) String() string {
+	if t.Value == "" {
+		return t.Key
+	}
+	return t.Key + ":" + t.Value
+}
+
+// TagSet holds the tags of a memo in layers. When they are merged, the
+// system tags (set by memo itself, e.g. author and source) take precedence
+// over the channel defaults from the config, which take precedence over
+// the tags given by the user: a key set in a layer hides the values for
+// that key from the layers below
+type TagSet struct {
+	system  []Tag
+	channel []Tag
+	user    []Tag
+}
+
+// AddSystem adds a tag set by memo itself
+func (s *TagSet) AddSystem(key, value string) {
+	s.system = append(s.system, Tag{Key: key, Value: value})
+}
+
+// AddChannel adds channel default tags
+func (s *TagSet) AddChannel(tags ...Tag) {
+	s.channel = append(s.channel, tags...)
+}
+
+// AddUser adds tags given by the user
+func (s *TagSet) AddUser(tags ...Tag) {
+	s.user = append(s.user, tags...)
+}
+
+// User returns the tags given by the user
+func (s TagSet) User() []Tag {
+	return s.user
+}
+
+// Merge returns the deduplicated tags of all layers, including BaseTag,
+// applying the layer precedence
+func (s TagSet) Merge() []Tag {
+	owned := map[string]bool{}
+	seen := map[Tag]bool{}
+	out := []Tag{{Key: BaseTag}}
+	seen[out[0]] = true
+
+	for _, layer := range [][]Tag{s.system, s.channel, s.user} {
+		keys := map[string]bool{}
+		for _, t := range layer {
+			if t.Key == "" || owned[t.Key] || seen[t] {
+				continue
+			}
+			seen[t] = true
+			keys[t.Key] = true
+			out = append(out, t)
+		}
+		for k := range keys {
+			owned[k] = true
+		}
+	}
+
+	return out
+}
+
+// Get returns the value of the key after merging, if it is set
+func (s TagSet) Get(key string) (string, bool) {
+	for _, t := range s.Merge() {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+// Strings returns the sorted canonical encodings of the merged tags
+func (s TagSet) Strings() []string {
+	var out []string
+	for _, t := range s.Merge() {
+		out = append(out, t.String())
+	}
+	sort.Strings(out)
+	return out
+}